@@ -1,25 +1,17 @@
 package auth
 
 import (
+    "context"
+    "errors"
     "fmt"
-    "log"
+    "log/slog"
     "net/http"
     "os"
-    "sync"
     "time"
-    "bytes"
     "io/ioutil"
     "encoding/json"
 )
 
-// Declaring variables for token management
-var (
-    accessToken  string
-    refreshToken string
-    tokenExpiry  time.Time
-    tokenMutex   sync.Mutex
-)
-
 // TokenResponse struct for tokens
 type TokenResponse struct {
     AccessToken  string `json:"access_token"`
@@ -29,93 +21,122 @@ type TokenResponse struct {
 
 // --- Public Token Management Functions ---
 
-// GetAccessToken retrieves the token, refreshes it if necessary
-func GetAccessToken() (string, error) {
-    tokenMutex.Lock()
-    defer tokenMutex.Unlock()
+// GetAccessToken retrieves the session's token, waiting for a shared refresh if it has expired
+func GetAccessToken(ctx context.Context, sessionID string) (string, error) {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return "", err
+    }
 
-    var err error
+    session.mu.Lock()
 
     // Check if the token is valid
-    if time.Now().Before(tokenExpiry) && accessToken != "" {
+    if session.tokenValid() {
         // Token is still valid
-        return accessToken, nil
+        token := session.accessToken
+        session.mu.Unlock()
+        return token, nil
     }
 
-    // Token expired or missing, refresh it
-    if refreshToken == "" {
-        err = fmt.Errorf("no refresh token available")
-    } else {
-        err = ExchangeOrRefreshToken("", true)
-        if err != nil {
-            log.Printf("Failed to refresh token: %v", err)
-        }
+    // Token expired or missing, refresh it unless the refresh token was already rejected
+    if session.refreshRejected {
+        session.mu.Unlock()
+        return "", ErrRefreshTokenRejected
+    }
+    if !session.canRefresh() {
+        session.mu.Unlock()
+        return "", fmt.Errorf("no refresh token available")
     }
 
-    return accessToken, err
-}
+    // Join the refresh in flight or start one, without holding the lock while waiting
+    call := session.refreshAsync()
+    session.mu.Unlock()
 
-// ExchangeOrRefreshToken handles the exchange/refresh of tokens
-func ExchangeOrRefreshToken(code string, isRefresh bool) error {
-    tokenMutex.Lock()
-    defer tokenMutex.Unlock()
+    if err := call.wait(ctx); err != nil {
+        return "", err
+    }
 
-    // Determine grant type
-    reqBody := map[string]string{
-        "client_id":     os.Getenv("CLIENT_ID"),
-        "client_secret": os.Getenv("CLIENT_SECRET"),
-        "redirect_uri":  os.Getenv("REDIRECT_URI"),
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.accessToken, nil
+}
+
+// RefreshAccessToken replaces a token SFMC rejected before it expired, concurrent callers holding the same stale token share one refresh
+func RefreshAccessToken(ctx context.Context, sessionID string, staleToken string) (string, error) {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return "", err
     }
-    if isRefresh {
-        reqBody["grant_type"] = "refresh_token"
-        reqBody["refresh_token"] = refreshToken
-    } else {
-        reqBody["grant_type"] = "authorization_code"
-        reqBody["code"] = code
+
+    session.mu.Lock()
+
+    // Another caller already replaced the stale token
+    if session.accessToken != staleToken && session.tokenValid() {
+        token := session.accessToken
+        session.mu.Unlock()
+        return token, nil
     }
 
-    jsonReqBody, _ := json.Marshal(reqBody)
-    authURL := os.Getenv("AUTHORIZATION_URL")
-    tokenEndpoint := fmt.Sprintf("%s/v2/token", authURL)
+    if session.refreshRejected {
+        session.mu.Unlock()
+        return "", ErrRefreshTokenRejected
+    }
+    if !session.canRefresh() {
+        session.mu.Unlock()
+        return "", fmt.Errorf("no refresh token available")
+    }
 
-    log.Printf("AUTHORIZATION_URL: %s", authURL)
+    call := session.refreshAsync()
+    session.mu.Unlock()
 
-    req, err := http.NewRequest("POST", tokenEndpoint, bytes.NewBuffer(jsonReqBody))
-    if err != nil {
-        return fmt.Errorf("failed to create token request: %v", err)
+    if err := call.wait(ctx); err != nil {
+        return "", err
     }
 
-    req.Header.Set("Content-Type", "application/json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.accessToken, nil
+}
+
+// ExchangeOrRefreshToken handles the exchange (with the login's PKCE verifier) or refresh of tokens for a session
+func ExchangeOrRefreshToken(ctx context.Context, sessionID string, code string, codeVerifier string, isRefresh bool) error {
+    session, err := getSession(sessionID)
     if err != nil {
-        return fmt.Errorf("failed to get tokens: %v", err)
+        return err
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        return fmt.Errorf("token endpoint returned non-200 status: %d", resp.StatusCode)
+    if isRefresh {
+        return session.refresh(ctx)
     }
 
-    var tokenRes TokenResponse
-    err = json.NewDecoder(resp.Body).Decode(&tokenRes)
+    tokenRes, err := fetchTokens(ctx, authorizationCodeGrant(code, codeVerifier))
     if err != nil {
-        return fmt.Errorf("error decoding token response: %v", err)
+        return err
     }
 
-    accessToken = tokenRes.AccessToken
-    refreshToken = tokenRes.RefreshToken
-    tokenExpiry = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
-
-    log.Printf("Tokens received: Access Token: %s, Refresh Token: %s", accessToken, refreshToken)
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    session.applyTokens(tokenRes)
     return nil
 }
 
+// RefreshRejected reports whether SFMC has rejected the session's refresh token
+func RefreshRejected(sessionID string) bool {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return false
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.refreshRejected
+}
+
 // --- User Info Retrieval Function ---
 
-// getUserInfo retrieves user information for Enterprise ID via the REST API
-func GetUserInfo() (string, error) {
-    token, err := GetAccessToken() // Get the access token
+// GetUserInfo retrieves user information for Enterprise ID via the REST API and stores the user's identity on the session
+func GetUserInfo(ctx context.Context, sessionID string) (string, error) {
+    token, err := GetAccessToken(ctx, sessionID) // Get the session's access token
     if err != nil {
         return "", err
     }
@@ -124,26 +145,25 @@ func GetUserInfo() (string, error) {
     url := fmt.Sprintf("%s/v2/userinfo", os.Getenv("AUTHORIZATION_URL"))
 
     // Create a new GET request
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
     if err != nil {
         return "", err
     }
 
     // Set the authorization header with the access token
     req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-    log.Printf("Authorization Header: Bearer %s", token)
 
     // Send the request
     client := &http.Client{}
     resp, err := client.Do(req)
     if err != nil {
-        log.Printf("Error sending request: %v", err)
+        slog.Error("Error sending user info request", "error", err)
         return "", err
     }
     defer resp.Body.Close()
 
     // Check if the response status is OK
-    log.Printf("Response status: %d", resp.StatusCode)
+    slog.Debug("User info response received", "status", resp.StatusCode)
     if resp.StatusCode != http.StatusOK {
         return "", fmt.Errorf("failed to fetch user information, status: %d", resp.StatusCode)
     }
@@ -151,38 +171,145 @@ func GetUserInfo() (string, error) {
     // Read the response body
     bodyBytes, err := ioutil.ReadAll(resp.Body)
     if err != nil {
-        log.Printf("Error reading response body: %v", err)
+        slog.Error("Error reading user info response body", "error", err)
         return "", err
     }
 
     // Parse the response body
     var userInfo struct {
+        User struct {
+            Sub string `json:"sub"` // ID of the SFMC user
+        } `json:"user"`
         Organization struct {
             EnterpriseID float64 `json:"enterprise_id"` // Expect Enterprise ID as a number
+            MemberID     float64 `json:"member_id"`     // MID of the business unit the token was issued for
         } `json:"organization"`
     }
 
     // Decode the response JSON
     err = json.Unmarshal(bodyBytes, &userInfo)
     if err != nil {
-        log.Printf("Error decoding JSON response: %v", err)
+        slog.Error("Error decoding user info response", "error", err)
         return "", err
     }
 
     // Convert the EnterpriseID to string
     enterpriseID := fmt.Sprintf("%.0f", userInfo.Organization.EnterpriseID)
 
+    // Remember the user, the Enterprise ID and the initial business unit on the session
+    if session, err := getSession(sessionID); err == nil {
+        session.mu.Lock()
+        session.userID = userInfo.User.Sub
+        session.enterpriseID = enterpriseID
+        if session.mid == "" && userInfo.Organization.MemberID != 0 {
+            session.mid = fmt.Sprintf("%.0f", userInfo.Organization.MemberID)
+        }
+        record, persist := session.storedSession()
+        session.mu.Unlock()
+
+        // The session can only be restored after a restart once its identity is known
+        if persist {
+            persistSession(record)
+        }
+    }
+
     // Return only the Enterprise ID
     return enterpriseID, nil
 }
 
+// --- Business Unit Functions ---
+
+// GetMID returns the MID of the session's active business unit
+func GetMID(sessionID string) string {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return ""
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.mid
+}
+
+// GetEnterpriseID returns the Enterprise ID of the session's user, empty until the user info has been loaded
+func GetEnterpriseID(sessionID string) string {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return ""
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.enterpriseID
+}
+
+// SwitchBusinessUnit requests a token scoped to the given MID and makes it the session's active business unit. If ctx
+// ends first, the switch still happens once the token arrives.
+func SwitchBusinessUnit(ctx context.Context, sessionID, mid string) error {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return err
+    }
+
+    if session.clientCredentials {
+        return fmt.Errorf("the server-to-server session is bound to SERVER_ACCOUNT_ID")
+    }
+
+    session.mu.Lock()
+
+    if !session.canRefresh() {
+        session.mu.Unlock()
+        return fmt.Errorf("no refresh token available")
+    }
+
+    // Let a refresh for the previous business unit finish so its token can't overwrite the new one
+    for session.refreshing != nil {
+        pending := session.refreshing
+        session.mu.Unlock()
+        if err := pending.wait(ctx); err != nil && ctx.Err() != nil {
+            return err
+        }
+        session.mu.Lock()
+    }
+
+    // The MID changes along with the token once SFMC issues one for the new business unit
+    call := session.switchAsync(mid)
+    session.mu.Unlock()
+
+    return call.wait(ctx)
+}
+
 // --- Handle Logout from auth_handler ---
 
-// LogoutTokens clears the stored tokens and expiry
-func LogoutTokens() {
-    tokenMutex.Lock()
-    defer tokenMutex.Unlock()
-    accessToken = ""
-    refreshToken = ""
-    tokenExpiry = time.Now()
+// LogoutTokens revokes the session's tokens at SFMC, clears them and removes the session from the store
+func LogoutTokens(ctx context.Context, sessionID string) error {
+    // The server-to-server session is shared and can't be logged out
+    if sessionID == ServerSessionID {
+        return errors.New("the server session can't be logged out")
+    }
+
+    sessionsMutex.RLock()
+    session, found := sessions[sessionID]
+    sessionsMutex.RUnlock()
+
+    if !found {
+        return ErrSessionNotFound
+    }
+
+    session.mu.Lock()
+    refreshToken := session.refreshToken
+    rejected := session.refreshRejected
+    session.accessToken = ""
+    session.refreshToken = ""
+    session.tokenExpiry = time.Now()
+    session.mu.Unlock()
+
+    deleteSession(sessionID)
+
+    // A rejected refresh token is already dead at SFMC
+    if refreshToken == "" || rejected {
+        return nil
+    }
+
+    return revokeToken(ctx, refreshToken, "refresh_token")
 }
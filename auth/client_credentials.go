@@ -0,0 +1,93 @@
+package auth
+
+import (
+    "context"
+    "crypto/subtle"
+    "fmt"
+    "log/slog"
+    "os"
+    "strings"
+)
+
+// ServerSessionID is the fixed session used by headless callers in server-to-server mode
+const ServerSessionID = "server"
+
+// --- Auth Mode Configuration ---
+
+// authMode returns the configured AUTH_MODE: "web" (default), "server" or "both"
+func authMode() string {
+    mode := strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_MODE")))
+    if mode == "" {
+        return "web"
+    }
+    return mode
+}
+
+// WebFlowEnabled reports whether the browser authorization-code flow is enabled
+func WebFlowEnabled() bool {
+    mode := authMode()
+    return mode == "web" || mode == "both"
+}
+
+// ServerToServerEnabled reports whether the client_credentials flow is enabled
+func ServerToServerEnabled() bool {
+    mode := authMode()
+    return mode == "server" || mode == "both"
+}
+
+// --- Server-to-Server Session ---
+
+// InitServerSession registers the server-to-server session and mints its first token to validate the credentials
+func InitServerSession(ctx context.Context) error {
+    if os.Getenv("SERVER_CLIENT_ID") == "" || os.Getenv("SERVER_CLIENT_SECRET") == "" {
+        return fmt.Errorf("SERVER_CLIENT_ID and SERVER_CLIENT_SECRET must be set when AUTH_MODE is %q", authMode())
+    }
+    if os.Getenv("SERVER_API_KEY") == "" {
+        return fmt.Errorf("SERVER_API_KEY must be set when AUTH_MODE is %q", authMode())
+    }
+
+    session := &Session{
+        ID:                ServerSessionID,
+        clientCredentials: true,
+    }
+
+    sessionsMutex.Lock()
+    sessions[ServerSessionID] = session
+    sessionsMutex.Unlock()
+
+    if _, err := GetAccessToken(ctx, ServerSessionID); err != nil {
+        return fmt.Errorf("failed to get server-to-server token: %v", err)
+    }
+
+    // The Enterprise ID is only needed for shared Data Extension lookups, so a failure here is not fatal
+    if _, err := GetUserInfo(ctx, ServerSessionID); err != nil {
+        slog.Warn("Could not retrieve Enterprise ID for server-to-server session", "error", err)
+    }
+
+    return nil
+}
+
+// ValidServerAPIKey reports whether the key presented by a headless caller matches SERVER_API_KEY
+func ValidServerAPIKey(key string) bool {
+    expected := os.Getenv("SERVER_API_KEY")
+    if expected == "" || key == "" {
+        return false
+    }
+    return subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1
+}
+
+// clientCredentialsGrant builds the token request body for the installed package's server-to-server credentials
+func clientCredentialsGrant() map[string]string {
+    reqBody := map[string]string{
+        "grant_type":    "client_credentials",
+        "client_id":     os.Getenv("SERVER_CLIENT_ID"),
+        "client_secret": os.Getenv("SERVER_CLIENT_SECRET"),
+    }
+
+    // Optional MID of the business unit the token should be issued for
+    if accountID := os.Getenv("SERVER_ACCOUNT_ID"); accountID != "" {
+        reqBody["account_id"] = accountID
+    }
+
+    return reqBody
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// How long a login started at /auth/login may take before its state expires
+const loginStateTTL = 10 * time.Minute
+
+// ErrInvalidLoginState is returned when an OAuth callback doesn't belong to a login started by this browser
+var ErrInvalidLoginState = errors.New("invalid or expired login state")
+
+// pendingLogin holds the PKCE verifier of a login waiting for its callback
+type pendingLogin struct {
+    codeVerifier string
+    expiresAt    time.Time
+}
+
+// Declaring the pending login store, keyed by the nonce embedded in the state
+var (
+    pendingLogins      = make(map[string]pendingLogin)
+    pendingLoginsMutex sync.Mutex
+)
+
+// Declaring the signing secret, loaded once from SESSION_SECRET
+var (
+    signingSecret     []byte
+    signingSecretOnce sync.Once
+)
+
+// LoginRequest carries everything the login handler needs to build the authorize URL
+type LoginRequest struct {
+    State         string
+    Nonce         string // Bound to the browser with a cookie and compared on callback
+    CodeChallenge string
+}
+
+// --- Signing Helpers ---
+
+// getSigningSecret returns SESSION_SECRET, or a random per-process secret when it isn't configured
+func getSigningSecret() []byte {
+    signingSecretOnce.Do(func() {
+        if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+            signingSecret = []byte(secret)
+            return
+        }
+
+        slog.Warn("SESSION_SECRET is not set, using a random secret that won't survive restarts")
+        signingSecret = make([]byte, 32)
+        if _, err := rand.Read(signingSecret); err != nil {
+            slog.Error("Failed to generate signing secret", "error", err)
+            os.Exit(1)
+        }
+    })
+    return signingSecret
+}
+
+// sign returns the base64url HMAC-SHA256 of the value
+func sign(value string) string {
+    mac := hmac.New(sha256.New, getSigningSecret())
+    mac.Write([]byte(value))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomString returns n random bytes encoded as base64url
+func randomString(n int) (string, error) {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// --- Login State and PKCE ---
+
+// NewLoginRequest creates a signed state and a PKCE verifier/challenge pair for a new login
+func NewLoginRequest() (*LoginRequest, error) {
+    nonce, err := randomString(16)
+    if err != nil {
+        return nil, err
+    }
+
+    // RFC 7636 verifier: 32 random bytes give 43 base64url characters
+    codeVerifier, err := randomString(32)
+    if err != nil {
+        return nil, err
+    }
+    challenge := sha256.Sum256([]byte(codeVerifier))
+
+    expiresAt := time.Now().Add(loginStateTTL)
+    payload := nonce + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+
+    pendingLoginsMutex.Lock()
+    pendingLogins[nonce] = pendingLogin{codeVerifier: codeVerifier, expiresAt: expiresAt}
+    pendingLoginsMutex.Unlock()
+
+    return &LoginRequest{
+        State:         payload + "." + sign(payload),
+        Nonce:         nonce,
+        CodeChallenge: base64.RawURLEncoding.EncodeToString(challenge[:]),
+    }, nil
+}
+
+// VerifyLoginState checks the callback's state against its signature, expiry and the browser's nonce cookie, and returns the PKCE verifier
+func VerifyLoginState(state, browserNonce string) (string, error) {
+    parts := strings.Split(state, ".")
+    if len(parts) != 3 {
+        return "", ErrInvalidLoginState
+    }
+
+    nonce, expiry, signature := parts[0], parts[1], parts[2]
+    if !hmac.Equal([]byte(signature), []byte(sign(nonce+"."+expiry))) {
+        return "", fmt.Errorf("%w: signature mismatch", ErrInvalidLoginState)
+    }
+
+    expiryUnix, err := strconv.ParseInt(expiry, 10, 64)
+    if err != nil || time.Now().After(time.Unix(expiryUnix, 0)) {
+        return "", fmt.Errorf("%w: state expired", ErrInvalidLoginState)
+    }
+
+    // The state must come back to the same browser that started the login
+    if browserNonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(browserNonce)) != 1 {
+        return "", fmt.Errorf("%w: login was started in another browser", ErrInvalidLoginState)
+    }
+
+    // Each state can be used only once
+    pendingLoginsMutex.Lock()
+    login, found := pendingLogins[nonce]
+    delete(pendingLogins, nonce)
+    pendingLoginsMutex.Unlock()
+
+    if !found || time.Now().After(login.expiresAt) {
+        return "", fmt.Errorf("%w: login already completed or unknown", ErrInvalidLoginState)
+    }
+
+    return login.codeVerifier, nil
+}
+
+// cleanupPendingLogins drops logins whose callback never arrived
+func cleanupPendingLogins() {
+    now := time.Now()
+
+    pendingLoginsMutex.Lock()
+    defer pendingLoginsMutex.Unlock()
+
+    for nonce, login := range pendingLogins {
+        if now.After(login.expiresAt) {
+            delete(pendingLogins, nonce)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestVerifyLoginStateRejectsForeignStates(t *testing.T) {
+    login, err := NewLoginRequest()
+    if err != nil {
+        t.Fatalf("NewLoginRequest() failed: %v", err)
+    }
+    parts := strings.Split(login.State, ".")
+
+    expiredPayload := login.Nonce + "." + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+    tests := []struct {
+        name         string
+        state        string
+        browserNonce string
+    }{
+        {"malformed state", "not-a-state", login.Nonce},
+        {"tampered signature", parts[0] + "." + parts[1] + "." + sign("other"), login.Nonce},
+        {"tampered expiry", parts[0] + "." + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "." + parts[2], login.Nonce},
+        {"expired state", expiredPayload + "." + sign(expiredPayload), login.Nonce},
+        {"no browser nonce", login.State, ""},
+        {"another browser", login.State, "other-nonce"},
+    }
+    for _, test := range tests {
+        if _, err := VerifyLoginState(test.state, test.browserNonce); !errors.Is(err, ErrInvalidLoginState) {
+            t.Errorf("%s: VerifyLoginState() error = %v, want ErrInvalidLoginState", test.name, err)
+        }
+    }
+
+    // None of the rejected callbacks may use up the login
+    if _, err := VerifyLoginState(login.State, login.Nonce); err != nil {
+        t.Errorf("VerifyLoginState() of the login's own state failed: %v", err)
+    }
+}
+
+func TestVerifyLoginStateIsSingleUse(t *testing.T) {
+    login, err := NewLoginRequest()
+    if err != nil {
+        t.Fatalf("NewLoginRequest() failed: %v", err)
+    }
+
+    codeVerifier, err := VerifyLoginState(login.State, login.Nonce)
+    if err != nil {
+        t.Fatalf("VerifyLoginState() failed: %v", err)
+    }
+    if codeVerifier == "" {
+        t.Errorf("VerifyLoginState() returned an empty PKCE verifier")
+    }
+
+    if _, err := VerifyLoginState(login.State, login.Nonce); !errors.Is(err, ErrInvalidLoginState) {
+        t.Errorf("second VerifyLoginState() error = %v, want ErrInvalidLoginState", err)
+    }
+}
+
+func TestCleanupPendingLoginsDropsOnlyExpiredLogins(t *testing.T) {
+    login, err := NewLoginRequest()
+    if err != nil {
+        t.Fatalf("NewLoginRequest() failed: %v", err)
+    }
+    stale, err := NewLoginRequest()
+    if err != nil {
+        t.Fatalf("NewLoginRequest() failed: %v", err)
+    }
+
+    pendingLoginsMutex.Lock()
+    expired := pendingLogins[stale.Nonce]
+    expired.expiresAt = time.Now().Add(-time.Second)
+    pendingLogins[stale.Nonce] = expired
+    pendingLoginsMutex.Unlock()
+
+    cleanupPendingLogins()
+
+    pendingLoginsMutex.Lock()
+    _, loginKept := pendingLogins[login.Nonce]
+    _, staleKept := pendingLogins[stale.Nonce]
+    pendingLoginsMutex.Unlock()
+
+    if !loginKept {
+        t.Errorf("cleanupPendingLogins() dropped a login that hasn't expired")
+    }
+    if staleKept {
+        t.Errorf("cleanupPendingLogins() kept an expired login")
+    }
+}
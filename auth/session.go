@@ -0,0 +1,152 @@
+package auth
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "log/slog"
+    "sync"
+    "time"
+)
+
+// How long a session stays alive without being used
+const sessionTTL = 8 * time.Hour
+
+// ErrSessionNotFound is returned when a session ID is unknown or has expired
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+// Session holds the tokens and identity of one logged-in user
+type Session struct {
+    ID string
+
+    userID            string
+    enterpriseID      string
+    mid               string // MID of the business unit the session's tokens are scoped to
+    clientCredentials bool // Server-to-server session that never expires and has no refresh token
+    accessToken       string
+    refreshToken      string
+    tokenExpiry       time.Time
+    refreshRejected   bool         // SFMC rejected the refresh token, the user must log in again
+    refreshing        *refreshCall // Refresh in flight, shared by every waiter
+    expiresAt         time.Time
+    mu                sync.Mutex
+}
+
+// Declaring the session store, keyed by the server-issued session ID
+var (
+    sessions      = make(map[string]*Session)
+    sessionsMutex sync.RWMutex
+)
+
+// --- Session Store Functions ---
+
+// NewSession creates an empty session and returns its ID
+func NewSession() (string, error) {
+    idBytes := make([]byte, 32)
+    if _, err := rand.Read(idBytes); err != nil {
+        return "", err
+    }
+
+    session := &Session{
+        ID:        hex.EncodeToString(idBytes),
+        expiresAt: time.Now().Add(sessionTTL),
+    }
+
+    sessionsMutex.Lock()
+    sessions[session.ID] = session
+    sessionsMutex.Unlock()
+
+    return session.ID, nil
+}
+
+// getSession looks up a live session and extends its lifetime
+func getSession(sessionID string) (*Session, error) {
+    sessionsMutex.RLock()
+    session, found := sessions[sessionID]
+    sessionsMutex.RUnlock()
+
+    if !found {
+        return nil, ErrSessionNotFound
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+
+    if session.clientCredentials {
+        return session, nil
+    }
+
+    if time.Now().After(session.expiresAt) {
+        return nil, ErrSessionNotFound
+    }
+    session.expiresAt = time.Now().Add(sessionTTL)
+
+    return session, nil
+}
+
+// SessionLive reports whether the session exists and hasn't expired, without extending its lifetime
+func SessionLive(sessionID string) bool {
+    sessionsMutex.RLock()
+    session, found := sessions[sessionID]
+    sessionsMutex.RUnlock()
+
+    if !found {
+        return false
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+    return session.clientCredentials || time.Now().Before(session.expiresAt)
+}
+
+// deleteSession removes the session from the store and the token store
+func deleteSession(sessionID string) {
+    sessionsMutex.Lock()
+    delete(sessions, sessionID)
+    sessionsMutex.Unlock()
+
+    forgetSession(sessionID)
+}
+
+// StartSessionCleanup periodically removes expired sessions from the store
+func StartSessionCleanup(interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for range ticker.C {
+            cleanupPendingLogins()
+
+            removed := cleanupExpiredSessions()
+            if removed > 0 {
+                slog.Info("Removed expired sessions", "count", removed)
+            }
+        }
+    }()
+}
+
+// cleanupExpiredSessions deletes every session past its expiry and returns how many were removed
+func cleanupExpiredSessions() int {
+    now := time.Now()
+    var expiredIDs []string
+
+    sessionsMutex.Lock()
+    for id, session := range sessions {
+        session.mu.Lock()
+        expired := !session.clientCredentials && now.After(session.expiresAt)
+        session.mu.Unlock()
+
+        if expired {
+            delete(sessions, id)
+            expiredIDs = append(expiredIDs, id)
+        }
+    }
+    sessionsMutex.Unlock()
+
+    // Update the token store without holding the session store lock
+    for _, id := range expiredIDs {
+        forgetSession(id)
+    }
+
+    return len(expiredIDs)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// How long a signed session cookie is accepted, the server-side session may expire sooner when idle
+const sessionCookieTTL = 24 * time.Hour
+
+// ErrInvalidSessionCookie is returned when a session cookie is malformed, tampered with or expired
+var ErrInvalidSessionCookie = errors.New("invalid session cookie")
+
+// Identity is the verified user behind a request
+type Identity struct {
+    SessionID    string `json:"sid"`
+    UserID       string `json:"uid"`
+    EnterpriseID string `json:"eid"`
+    MID          string `json:"mid"`
+    ExpiresAt    int64  `json:"exp"`
+}
+
+// --- Session Cookie Functions ---
+
+// NewSessionCookieValue signs the identity of a logged-in session into a cookie value
+func NewSessionCookieValue(sessionID string) (string, error) {
+    identity, err := sessionIdentity(sessionID)
+    if err != nil {
+        return "", err
+    }
+    identity.ExpiresAt = time.Now().Add(sessionCookieTTL).Unix()
+
+    payloadBytes, err := json.Marshal(identity)
+    if err != nil {
+        return "", err
+    }
+
+    payload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+    return payload + "." + sign(payload), nil
+}
+
+// VerifySessionCookie checks the cookie's signature and expiry and that it still matches its server-side session
+func VerifySessionCookie(value string) (*Identity, error) {
+    payload, signature, found := strings.Cut(value, ".")
+    if !found || !hmac.Equal([]byte(signature), []byte(sign(payload))) {
+        return nil, ErrInvalidSessionCookie
+    }
+
+    payloadBytes, err := base64.RawURLEncoding.DecodeString(payload)
+    if err != nil {
+        return nil, ErrInvalidSessionCookie
+    }
+
+    var identity Identity
+    if err := json.Unmarshal(payloadBytes, &identity); err != nil {
+        return nil, ErrInvalidSessionCookie
+    }
+
+    if time.Now().After(time.Unix(identity.ExpiresAt, 0)) {
+        return nil, fmt.Errorf("%w: expired", ErrInvalidSessionCookie)
+    }
+
+    // The session must still exist and belong to the same user, enterprise and business unit
+    current, err := sessionIdentity(identity.SessionID)
+    if err != nil {
+        return nil, err
+    }
+    if current.UserID != identity.UserID || current.EnterpriseID != identity.EnterpriseID || current.MID != identity.MID {
+        return nil, fmt.Errorf("%w: identity no longer matches the session", ErrInvalidSessionCookie)
+    }
+
+    return &identity, nil
+}
+
+// ServerIdentity returns the identity of the server-to-server session used by headless callers
+func ServerIdentity() (*Identity, error) {
+    return sessionIdentity(ServerSessionID)
+}
+
+// sessionIdentity reads the identity stored on a live session
+func sessionIdentity(sessionID string) (*Identity, error) {
+    session, err := getSession(sessionID)
+    if err != nil {
+        return nil, err
+    }
+
+    session.mu.Lock()
+    defer session.mu.Unlock()
+
+    return &Identity{
+        SessionID:    session.ID,
+        UserID:       session.userID,
+        EnterpriseID: session.enterpriseID,
+        MID:          session.mid,
+    }, nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Helper function to store a live session for the identity
+func addTestSession(identity Identity) *Session {
+    session := &Session{
+        ID:           identity.SessionID,
+        userID:       identity.UserID,
+        enterpriseID: identity.EnterpriseID,
+        mid:          identity.MID,
+        expiresAt:    time.Now().Add(time.Hour),
+    }
+
+    sessionsMutex.Lock()
+    sessions[session.ID] = session
+    sessionsMutex.Unlock()
+    return session
+}
+
+// Helper function to sign an identity the way NewSessionCookieValue does
+func signedCookie(t *testing.T, identity Identity) string {
+    payloadBytes, err := json.Marshal(identity)
+    if err != nil {
+        t.Fatalf("json.Marshal() failed: %v", err)
+    }
+    payload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+    return payload + "." + sign(payload)
+}
+
+func TestVerifySessionCookieChecksItsSignature(t *testing.T) {
+    identity := Identity{SessionID: "cookie-signature", UserID: "u1", EnterpriseID: "e1", MID: "1"}
+    addTestSession(identity)
+
+    value, err := NewSessionCookieValue(identity.SessionID)
+    if err != nil {
+        t.Fatalf("NewSessionCookieValue() failed: %v", err)
+    }
+    payload, signature, _ := strings.Cut(value, ".")
+
+    forged := identity
+    forged.MID = "2"
+    forged.ExpiresAt = time.Now().Add(time.Hour).Unix()
+    forgedPayload := strings.Split(signedCookie(t, forged), ".")[0]
+
+    tests := []struct {
+        name  string
+        value string
+    }{
+        {"empty cookie", ""},
+        {"no signature", payload},
+        {"tampered signature", payload + "." + sign("other")},
+        {"tampered payload", forgedPayload + "." + signature},
+        {"payload that isn't base64", "!!!." + sign("!!!")},
+        {"payload that isn't JSON", "bm90LWpzb24." + sign("bm90LWpzb24")},
+    }
+    for _, test := range tests {
+        if _, err := VerifySessionCookie(test.value); !errors.Is(err, ErrInvalidSessionCookie) {
+            t.Errorf("%s: VerifySessionCookie() error = %v, want ErrInvalidSessionCookie", test.name, err)
+        }
+    }
+
+    got, err := VerifySessionCookie(value)
+    if err != nil {
+        t.Fatalf("VerifySessionCookie() of a fresh cookie failed: %v", err)
+    }
+    if got.SessionID != identity.SessionID || got.UserID != identity.UserID || got.EnterpriseID != identity.EnterpriseID || got.MID != identity.MID {
+        t.Errorf("VerifySessionCookie() = %+v, want the identity of %+v", got, identity)
+    }
+}
+
+func TestVerifySessionCookieChecksTheSessionIdentity(t *testing.T) {
+    identity := Identity{SessionID: "cookie-identity", UserID: "u1", EnterpriseID: "e1", MID: "1"}
+    session := addTestSession(identity)
+
+    live := identity
+    live.ExpiresAt = time.Now().Add(time.Hour).Unix()
+
+    expired := identity
+    expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+    unknown := live
+    unknown.SessionID = "cookie-unknown"
+
+    tests := []struct {
+        name     string
+        identity Identity
+        mid      string // MID the session is switched to before the cookie is verified
+        want     error
+    }{
+        {"live session", live, "1", nil},
+        {"expired cookie", expired, "1", ErrInvalidSessionCookie},
+        {"unknown session", unknown, "1", ErrSessionNotFound},
+        {"session switched business unit", live, "2", ErrInvalidSessionCookie},
+    }
+    for _, test := range tests {
+        session.mu.Lock()
+        session.mid = test.mid
+        session.mu.Unlock()
+
+        _, err := VerifySessionCookie(signedCookie(t, test.identity))
+        if test.want == nil && err != nil {
+            t.Errorf("%s: VerifySessionCookie() failed: %v", test.name, err)
+        }
+        if test.want != nil && !errors.Is(err, test.want) {
+            t.Errorf("%s: VerifySessionCookie() error = %v, want %v", test.name, err, test.want)
+        }
+    }
+}
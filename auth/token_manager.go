@@ -0,0 +1,278 @@
+package auth
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "net/http"
+    "os"
+    "time"
+)
+
+// Tokens expiring within this window are treated as expired so they never run out mid-call
+const tokenExpirySkew = 30 * time.Second
+
+// Sessions whose token expires within this window are refreshed by the background refresher
+const proactiveRefreshWindow = 3 * time.Minute
+
+// A refresh is shared by every waiter, so it runs on its own deadline rather than any one caller's context
+const refreshTimeout = 30 * time.Second
+
+// ErrRefreshTokenRejected is returned once SFMC has rejected a session's refresh token, the user must log in again
+var ErrRefreshTokenRejected = errors.New("refresh token rejected, please log in again")
+
+// refreshCall is a token refresh in flight, every concurrent waiter shares its result
+type refreshCall struct {
+    done chan struct{}
+    err  error
+}
+
+// wait blocks until the refresh finishes or ctx is done, the refresh itself keeps running for the other waiters
+func (c *refreshCall) wait(ctx context.Context) error {
+    select {
+    case <-c.done:
+        return c.err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// --- Token Endpoint ---
+
+// fetchTokens posts a grant to the token endpoint without holding any session lock
+func fetchTokens(ctx context.Context, reqBody map[string]string) (TokenResponse, error) {
+    var tokenRes TokenResponse
+
+    jsonReqBody, _ := json.Marshal(reqBody)
+    authURL := os.Getenv("AUTHORIZATION_URL")
+    tokenEndpoint := fmt.Sprintf("%s/v2/token", authURL)
+
+    slog.Debug("Requesting tokens", "grant_type", reqBody["grant_type"], "authorization_url", authURL)
+
+    req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, bytes.NewBuffer(jsonReqBody))
+    if err != nil {
+        return tokenRes, fmt.Errorf("failed to create token request: %v", err)
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return tokenRes, fmt.Errorf("failed to get tokens: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        bodyBytes, _ := ioutil.ReadAll(resp.Body)
+
+        // SFMC answers a revoked or expired refresh token with an OAuth error code
+        var errorRes struct {
+            Error string `json:"error"`
+        }
+        if json.Unmarshal(bodyBytes, &errorRes) == nil && reqBody["grant_type"] == "refresh_token" {
+            switch errorRes.Error {
+            case "invalid_grant", "invalid_token", "unauthorized_client":
+                return tokenRes, fmt.Errorf("%w (%s)", ErrRefreshTokenRejected, errorRes.Error)
+            }
+        }
+
+        return tokenRes, fmt.Errorf("token endpoint returned non-200 status: %d", resp.StatusCode)
+    }
+
+    err = json.NewDecoder(resp.Body).Decode(&tokenRes)
+    if err != nil {
+        return tokenRes, fmt.Errorf("error decoding token response: %v", err)
+    }
+
+    return tokenRes, nil
+}
+
+// revokeToken asks SFMC to revoke a token, revoking the refresh token also invalidates its access tokens
+func revokeToken(ctx context.Context, token, tokenTypeHint string) error {
+    reqBody := map[string]string{
+        "token":           token,
+        "token_type_hint": tokenTypeHint,
+        "client_id":       os.Getenv("CLIENT_ID"),
+        "client_secret":   os.Getenv("CLIENT_SECRET"),
+    }
+
+    jsonReqBody, _ := json.Marshal(reqBody)
+    revokeEndpoint := fmt.Sprintf("%s/v2/revoke", os.Getenv("AUTHORIZATION_URL"))
+
+    req, err := http.NewRequestWithContext(ctx, "POST", revokeEndpoint, bytes.NewBuffer(jsonReqBody))
+    if err != nil {
+        return fmt.Errorf("failed to create revoke request: %v", err)
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("failed to revoke token: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("revoke endpoint returned non-200 status: %d", resp.StatusCode)
+    }
+
+    return nil
+}
+
+// authorizationCodeGrant builds the token request body for exchanging an authorization code with its PKCE verifier
+func authorizationCodeGrant(code, codeVerifier string) map[string]string {
+    return map[string]string{
+        "grant_type":    "authorization_code",
+        "code":          code,
+        "code_verifier": codeVerifier,
+        "client_id":     os.Getenv("CLIENT_ID"),
+        "client_secret": os.Getenv("CLIENT_SECRET"),
+        "redirect_uri":  os.Getenv("REDIRECT_URI"),
+    }
+}
+
+// refreshGrant builds the token request body for a refresh scoped to the MID, the caller must hold session.mu
+func (s *Session) refreshGrant(mid string) map[string]string {
+    // Server-to-server sessions have no refresh token and simply mint a new one
+    if s.clientCredentials {
+        return clientCredentialsGrant()
+    }
+
+    reqBody := map[string]string{
+        "grant_type":    "refresh_token",
+        "refresh_token": s.refreshToken,
+        "client_id":     os.Getenv("CLIENT_ID"),
+        "client_secret": os.Getenv("CLIENT_SECRET"),
+        "redirect_uri":  os.Getenv("REDIRECT_URI"),
+    }
+
+    // Scope the token to the business unit
+    if mid != "" {
+        reqBody["account_id"] = mid
+    }
+
+    return reqBody
+}
+
+// --- Session Token State ---
+
+// applyTokens stores a token response on the session, the caller must hold session.mu
+func (s *Session) applyTokens(tokenRes TokenResponse) {
+    s.accessToken = tokenRes.AccessToken
+    if tokenRes.RefreshToken != "" {
+        s.refreshToken = tokenRes.RefreshToken
+    }
+    s.tokenExpiry = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+    s.refreshRejected = false
+
+    slog.Debug("Tokens received", "user_id", s.userID, "mid", s.mid, "expires_in", tokenRes.ExpiresIn)
+}
+
+// tokenValid reports whether the access token can still be used, the caller must hold session.mu
+func (s *Session) tokenValid() bool {
+    return s.accessToken != "" && time.Now().Add(tokenExpirySkew).Before(s.tokenExpiry)
+}
+
+// canRefresh reports whether the session has credentials to get a new token, the caller must hold session.mu
+func (s *Session) canRefresh() bool {
+    return s.clientCredentials || (s.refreshToken != "" && !s.refreshRejected)
+}
+
+// refreshAsync starts a refresh unless one is already running and returns the call to wait on, the caller must hold session.mu
+func (s *Session) refreshAsync() *refreshCall {
+    if s.refreshing != nil {
+        return s.refreshing
+    }
+    return s.startRefresh(s.refreshGrant(s.mid), "")
+}
+
+// switchAsync starts a refresh for a token scoped to another business unit. The session keeps its MID until the new
+// token arrives, then both change together so no request pairs one business unit's token with the other's MID. The
+// caller must hold session.mu and make sure no refresh is running.
+func (s *Session) switchAsync(mid string) *refreshCall {
+    return s.startRefresh(s.refreshGrant(mid), mid)
+}
+
+// startRefresh posts the grant in the background, switching the session to the MID when it's set and the grant
+// succeeds, the caller must hold session.mu
+func (s *Session) startRefresh(reqBody map[string]string, mid string) *refreshCall {
+    call := &refreshCall{done: make(chan struct{})}
+    s.refreshing = call
+
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+        tokenRes, err := fetchTokens(ctx, reqBody)
+        cancel()
+
+        s.mu.Lock()
+        if err == nil {
+            if mid != "" {
+                s.mid = mid
+            }
+            s.applyTokens(tokenRes)
+        } else {
+            slog.Warn("Failed to refresh token", "user_id", s.userID, "mid", s.mid, "error", err)
+            if errors.Is(err, ErrRefreshTokenRejected) {
+                s.refreshRejected = true
+            }
+        }
+        record, persist := s.storedSession()
+        call.err = err
+        s.refreshing = nil
+        s.mu.Unlock()
+
+        close(call.done)
+
+        // Keep the token store in step with the latest (possibly rotated) refresh token
+        if errors.Is(err, ErrRefreshTokenRejected) {
+            forgetSession(s.ID)
+        } else if err == nil && persist {
+            persistSession(record)
+        }
+    }()
+
+    return call
+}
+
+// refresh runs a single-flight refresh and waits for its result
+func (s *Session) refresh(ctx context.Context) error {
+    s.mu.Lock()
+    call := s.refreshAsync()
+    s.mu.Unlock()
+
+    return call.wait(ctx)
+}
+
+// --- Background Refresh ---
+
+// StartTokenRefresher periodically refreshes tokens that are about to expire so requests never wait on a refresh
+func StartTokenRefresher(interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for range ticker.C {
+            refreshExpiringTokens()
+        }
+    }()
+}
+
+// refreshExpiringTokens starts a refresh for every live session whose token expires soon
+func refreshExpiringTokens() {
+    sessionsMutex.RLock()
+    defer sessionsMutex.RUnlock()
+
+    deadline := time.Now().Add(proactiveRefreshWindow)
+    for _, session := range sessions {
+        session.mu.Lock()
+        live := session.clientCredentials || time.Now().Before(session.expiresAt)
+        if live && session.canRefresh() && session.refreshing == nil && session.tokenExpiry.Before(deadline) {
+            session.refreshAsync()
+        }
+        session.mu.Unlock()
+    }
+}
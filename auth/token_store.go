@@ -0,0 +1,325 @@
+package auth
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Default location of the encrypted token file
+const defaultTokenStorePath = "data/tokens.enc"
+
+// StoredSession is the part of a session that survives a restart, access tokens are minted again on restore
+type StoredSession struct {
+    ID           string    `json:"id"`
+    UserID       string    `json:"userId"`
+    EnterpriseID string    `json:"enterpriseId"`
+    MID          string    `json:"mid"`
+    RefreshToken string    `json:"refreshToken"`
+    SavedAt      time.Time `json:"savedAt"`
+}
+
+// TokenStore persists sessions' refresh tokens, any new backend only needs to implement this interface
+type TokenStore interface {
+    Save(session StoredSession) error
+    Delete(sessionID string) error
+    LoadAll() ([]StoredSession, error)
+}
+
+// The active token store, in memory until InitTokenStore configures another one
+var tokenStore TokenStore = newMemoryTokenStore()
+
+// InitTokenStore selects the token store from TOKEN_STORE: "memory" (default) or "file"
+func InitTokenStore() error {
+    switch strings.ToLower(os.Getenv("TOKEN_STORE")) {
+    case "", "memory":
+        tokenStore = newMemoryTokenStore()
+        return nil
+    case "file":
+        path := os.Getenv("TOKEN_STORE_PATH")
+        if path == "" {
+            path = defaultTokenStorePath
+        }
+
+        store, err := newFileTokenStore(path, os.Getenv("TOKEN_STORE_KEY"))
+        if err != nil {
+            return err
+        }
+        tokenStore = store
+        return nil
+    default:
+        return fmt.Errorf("unknown TOKEN_STORE %q, expected memory or file", os.Getenv("TOKEN_STORE"))
+    }
+}
+
+// RestoreSessions loads the stored sessions and validates each one by refreshing its token, returning how many were restored
+func RestoreSessions(ctx context.Context) (int, error) {
+    records, err := tokenStore.LoadAll()
+    if err != nil {
+        return 0, err
+    }
+
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    restored := 0
+
+    for _, record := range records {
+        // Sessions untouched for longer than the session lifetime would have expired anyway
+        if record.RefreshToken == "" || time.Since(record.SavedAt) > sessionTTL {
+            forgetSession(record.ID)
+            continue
+        }
+
+        session := &Session{
+            ID:           record.ID,
+            userID:       record.UserID,
+            enterpriseID: record.EnterpriseID,
+            mid:          record.MID,
+            refreshToken: record.RefreshToken,
+            expiresAt:    time.Now().Add(sessionTTL),
+        }
+
+        sessionsMutex.Lock()
+        sessions[session.ID] = session
+        sessionsMutex.Unlock()
+
+        wg.Add(1)
+        go func(session *Session) {
+            defer wg.Done()
+
+            err := session.refresh(ctx)
+            if errors.Is(err, ErrRefreshTokenRejected) {
+                // SFMC no longer accepts the token, the user has to log in again
+                deleteSession(session.ID)
+                return
+            }
+            if err != nil {
+                // Keep the session on transient errors, the next request retries the refresh
+                slog.Warn("Could not validate restored session", "user_id", session.userID, "error", err)
+            }
+
+            mu.Lock()
+            restored++
+            mu.Unlock()
+        }(session)
+    }
+
+    wg.Wait()
+    return restored, nil
+}
+
+// --- Persistence Hooks ---
+
+// storedSession snapshots what should be persisted, the caller must hold session.mu
+func (s *Session) storedSession() (StoredSession, bool) {
+    // Server-to-server sessions mint new tokens from their credentials and sessions without an identity can't be matched to a cookie
+    if s.clientCredentials || s.refreshToken == "" || s.userID == "" {
+        return StoredSession{}, false
+    }
+
+    return StoredSession{
+        ID:           s.ID,
+        UserID:       s.userID,
+        EnterpriseID: s.enterpriseID,
+        MID:          s.mid,
+        RefreshToken: s.refreshToken,
+        SavedAt:      time.Now(),
+    }, true
+}
+
+// persistSession saves the snapshot without holding any session lock
+func persistSession(record StoredSession) {
+    // A refresh can finish after the user logged out, don't bring the session back
+    sessionsMutex.RLock()
+    _, live := sessions[record.ID]
+    sessionsMutex.RUnlock()
+    if !live {
+        return
+    }
+
+    if err := tokenStore.Save(record); err != nil {
+        slog.Error("Failed to persist session", "user_id", record.UserID, "error", err)
+    }
+}
+
+// forgetSession removes the session from the token store
+func forgetSession(sessionID string) {
+    if err := tokenStore.Delete(sessionID); err != nil {
+        slog.Error("Failed to remove session from token store", "error", err)
+    }
+}
+
+// --- In-Memory Store ---
+
+// memoryTokenStore keeps sessions for the life of the process only
+type memoryTokenStore struct {
+    mu      sync.Mutex
+    records map[string]StoredSession
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+    return &memoryTokenStore{records: make(map[string]StoredSession)}
+}
+
+func (m *memoryTokenStore) Save(session StoredSession) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.records[session.ID] = session
+    return nil
+}
+
+func (m *memoryTokenStore) Delete(sessionID string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.records, sessionID)
+    return nil
+}
+
+func (m *memoryTokenStore) LoadAll() ([]StoredSession, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    records := make([]StoredSession, 0, len(m.records))
+    for _, record := range m.records {
+        records = append(records, record)
+    }
+    return records, nil
+}
+
+// --- Encrypted File Store ---
+
+// fileTokenStore keeps every session in a single AES-GCM encrypted file that is rewritten on each change
+type fileTokenStore struct {
+    path    string
+    aead    cipher.AEAD
+    mu      sync.Mutex
+    records map[string]StoredSession
+}
+
+// newFileTokenStore opens the store at path, key must be 32 base64-encoded bytes (e.g. openssl rand -base64 32)
+func newFileTokenStore(path, key string) (*fileTokenStore, error) {
+    if key == "" {
+        return nil, errors.New("TOKEN_STORE_KEY must be set when TOKEN_STORE is file")
+    }
+
+    keyBytes, err := base64.StdEncoding.DecodeString(key)
+    if err != nil || len(keyBytes) != 32 {
+        return nil, errors.New("TOKEN_STORE_KEY must be 32 bytes encoded as base64")
+    }
+
+    block, err := aes.NewCipher(keyBytes)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    store := &fileTokenStore{path: path, aead: aead, records: make(map[string]StoredSession)}
+    if err := store.read(); err != nil {
+        return nil, err
+    }
+    return store, nil
+}
+
+func (f *fileTokenStore) Save(session StoredSession) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.records[session.ID] = session
+    return f.write()
+}
+
+func (f *fileTokenStore) Delete(sessionID string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if _, found := f.records[sessionID]; !found {
+        return nil
+    }
+    delete(f.records, sessionID)
+    return f.write()
+}
+
+func (f *fileTokenStore) LoadAll() ([]StoredSession, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    records := make([]StoredSession, 0, len(f.records))
+    for _, record := range f.records {
+        records = append(records, record)
+    }
+    return records, nil
+}
+
+// read decrypts the file into memory, a missing file is an empty store
+func (f *fileTokenStore) read() error {
+    data, err := os.ReadFile(f.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read token store: %v", err)
+    }
+
+    nonceSize := f.aead.NonceSize()
+    if len(data) < nonceSize {
+        return errors.New("token store file is corrupted")
+    }
+
+    plaintext, err := f.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+    if err != nil {
+        return errors.New("failed to decrypt token store, check TOKEN_STORE_KEY")
+    }
+
+    if err := json.Unmarshal(plaintext, &f.records); err != nil {
+        return fmt.Errorf("failed to decode token store: %v", err)
+    }
+    return nil
+}
+
+// write encrypts the records and atomically replaces the file, the caller must hold f.mu
+func (f *fileTokenStore) write() error {
+    plaintext, err := json.Marshal(f.records)
+    if err != nil {
+        return err
+    }
+
+    nonce := make([]byte, f.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return err
+    }
+    data := f.aead.Seal(nonce, nonce, plaintext, nil)
+
+    dir := filepath.Dir(f.path)
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(dir, ".tokens-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+    "context"
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "asset_relationship_finder/auth"
+    "asset_relationship_finder/services"
+)
+
+// ---- API v1 ----
+
+// The OpenAPI description of the /api/v1 routes, served at /api/v1/openapi.json
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// APIAsset identifies the asset a v1 response is about
+type APIAsset struct {
+    Type string `json:"type"`
+    ID   string `json:"id"`
+    Name string `json:"name,omitempty"`
+}
+
+// APIRelationshipsResponse is the body of GET /api/v1/assets/{type}/{id}/relationships
+type APIRelationshipsResponse struct {
+    Asset         APIAsset                      `json:"asset"`
+    Relationships map[string]interface{}        `json:"relationships"`
+    Status        map[string]RelationshipStatus `json:"status,omitempty"`
+}
+
+// apiError is a request error with the status and code it's answered with
+type apiError struct {
+    status  int
+    code    string
+    message string
+}
+
+func (e *apiError) Error() string {
+    return e.message
+}
+
+// apiAssetType tells how an asset type in the URL is looked up, "by" picks the identifier the {id} holds
+type apiAssetType struct {
+    sourceType string
+    by         []string // Accepted values of "by", the first one is the default
+    resolve    func(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error)
+    graphRef   sourceRef // How the resolved asset is found in the account index
+}
+
+// Asset types of the v1 routes
+var apiAssetTypes = map[string]apiAssetType{
+    "data-extension": {sourceDataExtension, []string{"key", "name"}, resolveDataExtensionV1, dataExtensionRef},
+    "cloud-page":     {sourceCloudPage, []string{"id"}, resolveCloudPageV1, cloudPageRef},
+    "email":          {sourceEmail, []string{"id", "name"}, resolveEmailV1, emailRef},
+    "query":          {sourceActivity, []string{"name"}, activityResolverV1("Queries"), activityRef(services.AssetQuery)},
+    "import":         {sourceActivity, []string{"name"}, activityResolverV1("Import Activities"), activityRef(services.AssetImport)},
+    "script":         {sourceActivity, []string{"name"}, activityResolverV1("Scripts"), activityRef(services.AssetScript)},
+    "filter":         {sourceActivity, []string{"name"}, activityResolverV1("Filter Activities"), activityRef(services.AssetFilter)},
+}
+
+// AssetRelationshipsV1 serves GET /api/v1/assets/{type}/{id}/relationships?include=...&by=...
+func AssetRelationshipsV1(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet) {
+        return
+    }
+
+    // Derived from the request, so a client disconnect cancels every outstanding SFMC call
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+
+    typeName, id := r.PathValue("type"), r.PathValue("id")
+    assetType, by, selection, err := parseAssetQuery(typeName, r.URL.Query().Get("by"), r.URL.Query().Get("include"))
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    // Find the asset, then look up the selected relationships
+    source, cacheKey, err := assetType.resolve(ctx, identity, id, by)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    response, err := runRelationships(ctx, assetType.sourceType, source, selection, cacheKey)
+    if err != nil {
+        writeUnauthenticated(w, err)
+        return
+    }
+
+    sendJSONResponse(w, newAPIRelationshipsResponse(typeName, id, response))
+}
+
+// Helper function to build the v1 body of the relationships found for an asset
+func newAPIRelationshipsResponse(typeName, id string, response RelationshipResponse) APIRelationshipsResponse {
+    return APIRelationshipsResponse{
+        Asset:         APIAsset{Type: typeName, ID: id, Name: response.Name},
+        Relationships: response.Relationships,
+        Status:        response.Status,
+    }
+}
+
+// OpenAPIHandler serves the OpenAPI description of the v1 routes, clients can be generated from it
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet) {
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(openAPIDocument)
+}
+
+// APINotFound answers v1 paths that don't match a route
+func APINotFound(w http.ResponseWriter, r *http.Request) {
+    writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("No API route matches %s.", r.URL.Path))
+}
+
+// parseAssetQuery checks the asset type, by and include of a lookup and returns the by to use and the selection
+func parseAssetQuery(typeName, by, include string) (apiAssetType, string, map[string]bool, error) {
+    assetType, found := apiAssetTypes[typeName]
+    if !found {
+        return apiAssetType{}, "", nil, &apiError{http.StatusNotFound, "unknown_asset_type", fmt.Sprintf("Unknown asset type %q.", typeName)}
+    }
+
+    if by == "" {
+        by = assetType.by[0]
+    }
+    if !slices.Contains(assetType.by, by) {
+        return apiAssetType{}, "", nil, &apiError{http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("A %s can be looked up by %s.", typeName, strings.Join(assetType.by, " or "))}
+    }
+
+    selection, err := parseInclude(include, assetType.sourceType)
+    if err != nil {
+        return apiAssetType{}, "", nil, err
+    }
+    return assetType, by, selection, nil
+}
+
+// parseInclude turns the comma separated include parameter into a selection, every relationship when it's empty
+func parseInclude(include, sourceType string) (map[string]bool, error) {
+    var keys []string
+    for _, provider := range relationshipProviders(sourceType) {
+        keys = append(keys, provider.Key())
+    }
+
+    selection := make(map[string]bool)
+    if strings.TrimSpace(include) == "" {
+        for _, key := range keys {
+            selection[key] = true
+        }
+        return selection, nil
+    }
+
+    for _, key := range strings.Split(include, ",") {
+        key = strings.TrimSpace(key)
+        if !slices.Contains(keys, key) {
+            return nil, &apiError{http.StatusBadRequest, "invalid_include", fmt.Sprintf("Unknown relationship %q, expected one of: %s.", key, strings.Join(keys, ", "))}
+        }
+        selection[key] = true
+    }
+    return selection, nil
+}
+
+// parseDepth reads the depth parameter of a graph walk, the number of hops to go
+func parseDepth(value string, defaultDepth, maxDepth int) (int, error) {
+    if value == "" {
+        return defaultDepth, nil
+    }
+    depth, err := strconv.Atoi(value)
+    if err != nil || depth < 1 || depth > maxDepth {
+        return 0, &apiError{http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("The depth must be a number from 1 to %d.", maxDepth)}
+    }
+    return depth, nil
+}
+
+// ---- Asset Resolvers ----
+
+func resolveDataExtensionV1(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error) {
+    if identity.EnterpriseID == "" {
+        return AssetSource{}, "", &apiError{http.StatusUnauthorized, "unauthenticated", "The Enterprise ID of the session is unknown, please log in again."}
+    }
+
+    req := DataExtensionRequest{CustomerKey: id}
+    if by == "name" {
+        req = DataExtensionRequest{Name: id}
+    }
+    return resolveDataExtension(ctx, identity, req)
+}
+
+func resolveCloudPageV1(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error) {
+    // CloudPages are only searched for in other assets' content, so there's nothing to look up first
+    return AssetSource{SessionID: identity.SessionID, ID: id}, "", nil
+}
+
+func resolveEmailV1(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error) {
+    emailID, emailName := id, ""
+    if by == "name" {
+        emailID, emailName = "", id
+    }
+
+    email, err := services.GetEmailByIDOrName(ctx, identity.SessionID, emailID, emailName)
+    if errors.Is(err, services.ErrEmailNotFound) {
+        return AssetSource{}, "", errAssetNotFound
+    }
+    if err != nil {
+        return AssetSource{}, "", err
+    }
+    return AssetSource{SessionID: identity.SessionID, ID: email.ID.String(), Name: email.Name}, "", nil
+}
+
+// Helper function to build the resolver of an automation activity type
+func activityResolverV1(activityType string) func(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error) {
+    return func(ctx context.Context, identity *auth.Identity, id, by string) (AssetSource, string, error) {
+        activityObjectID, err := resolveActivity(ctx, identity.SessionID, activityType, id)
+        if err != nil {
+            return AssetSource{}, "", err
+        }
+        return AssetSource{SessionID: identity.SessionID, ObjectID: activityObjectID, Name: id}, "", nil
+    }
+}
+
+// ---- API Errors ----
+
+// Helper function to reject any other method with a JSON 405, the Allow header lists the accepted ones
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+    if slices.Contains(methods, r.Method) {
+        return true
+    }
+    w.Header().Set("Allow", strings.Join(methods, ", "))
+    writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("%s is not allowed here, use %s.", r.Method, strings.Join(methods, " or ")))
+    return false
+}
+
+// writeAPIError sends the structured error body used by the middleware and every v1 route
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(APIErrorResponse{Error: APIError{Code: code, Message: message}}); err != nil {
+        slog.Error("Error encoding error response", "error", err)
+    }
+}
+
+// writeAPIServiceError answers with the status and code matching an error from a lookup
+func writeAPIServiceError(w http.ResponseWriter, err error) {
+    status, body := apiServiceError(err)
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(APIErrorResponse{Error: body}); err != nil {
+        slog.Error("Error encoding error response", "error", err)
+    }
+}
+
+// apiServiceError returns the status and error body matching an error from a lookup
+func apiServiceError(err error) (int, APIError) {
+    var requestErr *apiError
+    var soapErr *services.SOAPError
+    switch {
+    case errors.As(err, &requestErr):
+        return requestErr.status, APIError{Code: requestErr.code, Message: requestErr.message}
+    case errors.Is(err, services.ErrAuthExpired):
+        return http.StatusUnauthorized, unauthenticatedError(err)
+    case errors.Is(err, errAssetNotFound):
+        return http.StatusNotFound, APIError{Code: "not_found", Message: "No asset found with this identifier."}
+    case errors.Is(err, errUnsupportedActivityType):
+        return http.StatusBadRequest, APIError{Code: "unsupported_activity_type", Message: err.Error()}
+    case errors.As(err, &soapErr):
+        return soapErrorStatus(soapErr.Kind), APIError{Code: string(soapErr.Kind), Message: soapErr.Message}
+    case errors.Is(err, context.DeadlineExceeded):
+        return http.StatusGatewayTimeout, APIError{Code: "timeout", Message: "The lookup timed out, please try again."}
+    default:
+        slog.Error("API lookup failed", "error", err)
+        return http.StatusBadGateway, APIError{Code: "sfmc_error", Message: "The request to Salesforce Marketing Cloud failed."}
+    }
+}
@@ -1,55 +1,225 @@
 package handlers
 
 import (
-    "fmt"
-    "log"
+    "errors"
+    "html/template"
+    "log/slog"
     "net/http"
+    "net/url"
     "os"
     "asset_relationship_finder/auth"  // Import the auth package for token management
+    "asset_relationship_finder/jobs"
+    "asset_relationship_finder/services"
 )
 
+// Name of the signed cookie holding the user's identity and session ID
+const sessionCookieName = "arfSession"
+
+// Name of the cookie binding a pending login to the browser that started it
+const loginNonceCookieName = "loginNonce"
+
+// Page shown when a login callback is rejected
+var authErrorPage = template.Must(template.New("authError").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Login failed - SFMC Asset Finder</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" crossorigin="anonymous">
+</head>
+<body>
+    <div class="container mt-5">
+        <div class="alert alert-danger">
+            <h5 class="fw-bold">Login failed</h5>
+            <p>{{.}}</p>
+            <a href="/auth/login" class="btn btn-primary">Log in again</a>
+        </div>
+    </div>
+</body>
+</html>`))
+
+// Page shown after logging out, SFMC's Logout Endpoint lands here
+var signedOutPage = template.Must(template.New("signedOut").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Signed out - SFMC Asset Finder</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" crossorigin="anonymous">
+</head>
+<body>
+    <div class="container mt-5">
+        <div class="alert alert-secondary">
+            <h5 class="fw-bold">You have been signed out</h5>
+            <p>Your Salesforce Marketing Cloud tokens were revoked and your cached results were cleared.</p>
+            <a href="/auth/login" class="btn btn-primary">Log in again</a>
+        </div>
+    </div>
+</body>
+</html>`))
+
+// setSessionCookie signs the session's identity into the session cookie
+func setSessionCookie(w http.ResponseWriter, sessionID string) error {
+    value, err := auth.NewSessionCookieValue(sessionID)
+    if err != nil {
+        return err
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    value,
+        Path:     "/",
+        HttpOnly: true,                      // Ensures the cookie is only accessible through HTTP(S)
+        Secure:   true,                      // Ensures the cookie is only sent over HTTPS
+        SameSite: http.SameSiteNoneMode,     // Allows the cookie to be sent in cross-site requests (e.g., iframes)
+    })
+    return nil
+}
+
+// clearCookie expires a cookie in the browser
+func clearCookie(w http.ResponseWriter, name string) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     name,
+        Value:    "",
+        Path:     "/",
+        MaxAge:   -1,
+        HttpOnly: true,
+        Secure:   true,
+        SameSite: http.SameSiteNoneMode,
+    })
+}
+
+// renderAuthError shows the login error page with the given status code
+func renderAuthError(w http.ResponseWriter, message string, statusCode int) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.WriteHeader(statusCode)
+    if err := authErrorPage.Execute(w, message); err != nil {
+        slog.Error("Error rendering auth error page", "error", err)
+    }
+}
+
 // SalesforceLoginHandler handles Salesforce login by redirecting to the OAuth authorization page
 func SalesforceLoginHandler(w http.ResponseWriter, r *http.Request) {
-    authURL := fmt.Sprintf("%s/v2/authorize?response_type=code&client_id=%s&redirect_uri=%s",
-        os.Getenv("AUTHORIZATION_URL"),
-        os.Getenv("CLIENT_ID"),
-        os.Getenv("REDIRECT_URI"))
+    if !auth.WebFlowEnabled() {
+        http.Error(w, "Web login is disabled in this auth mode", http.StatusNotFound)
+        return
+    }
+
+    // Generate the signed state and PKCE pair for this login
+    loginRequest, err := auth.NewLoginRequest()
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error creating login request", "error", err)
+        http.Error(w, "Failed to start login", http.StatusInternalServerError)
+        return
+    }
+
+    // Bind the login to this browser so a callback started elsewhere is rejected
+    http.SetCookie(w, &http.Cookie{
+        Name:     loginNonceCookieName,
+        Value:    loginRequest.Nonce,
+        Path:     "/",
+        MaxAge:   600,
+        HttpOnly: true,
+        Secure:   true,
+        SameSite: http.SameSiteNoneMode,  // The login runs inside the SFMC iframe
+    })
+
+    params := url.Values{}
+    params.Set("response_type", "code")
+    params.Set("client_id", os.Getenv("CLIENT_ID"))
+    params.Set("redirect_uri", os.Getenv("REDIRECT_URI"))
+    params.Set("state", loginRequest.State)
+    params.Set("code_challenge", loginRequest.CodeChallenge)
+    params.Set("code_challenge_method", "S256")
+
+    authURL := os.Getenv("AUTHORIZATION_URL") + "/v2/authorize?" + params.Encode()
     http.Redirect(w, r, authURL, http.StatusFound)
 }
 
-// SalesforceLogoutHandler handles Salesforce logout by invalidating local tokens and clearing the session
+// SalesforceLogoutHandler revokes the session's tokens, purges its cached results and indexes, cancels its jobs, clears its cookies and redirects to the signed-out page
 func SalesforceLogoutHandler(w http.ResponseWriter, r *http.Request) {
-    auth.LogoutTokens()  // Call the function from auth package to clear tokens
-    log.Println("User logged out and tokens invalidated.")
+    if cookieValue, err := getCookieValue(r, sessionCookieName); err == nil {
+        if identity, err := auth.VerifySessionCookie(cookieValue); err == nil {
+            purgeSessionCache(identity.SessionID)
+            services.ForgetIndexes(identity.SessionID)
+            jobs.CancelOwner(identity.SessionID)
+            if err := auth.LogoutTokens(r.Context(), identity.SessionID); err != nil {
+                slog.WarnContext(r.Context(), "Error revoking tokens on logout", "error", err)
+            }
+        }
+    }
+
+    clearCookie(w, sessionCookieName)
+    clearCookie(w, "entID")
+    slog.InfoContext(r.Context(), "User logged out")
+
+    http.Redirect(w, r, "/auth/signed-out", http.StatusFound)
+}
+
+// SignedOutHandler shows the page confirming the user has been signed out
+func SignedOutHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    if err := signedOutPage.Execute(w, nil); err != nil {
+        slog.Error("Error rendering signed-out page", "error", err)
+    }
 }
 
 // HomeHandler acts as both the home page handler and OAuth callback handler
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-    code := r.URL.Query().Get("code")
-    if code != "" {
-        log.Printf("Authorization code received: %s", code)
-        err := auth.ExchangeOrRefreshToken(code, false)  // Use the auth package to exchange tokens
+    query := r.URL.Query()
+
+    // SFMC redirects back with an error when the user denies access
+    if oauthError := query.Get("error"); oauthError != "" && auth.WebFlowEnabled() {
+        slog.WarnContext(r.Context(), "Authorization failed", "oauth_error", oauthError)
+        renderAuthError(w, "Salesforce Marketing Cloud did not authorize the login ("+oauthError+").", http.StatusUnauthorized)
+        return
+    }
+
+    code := query.Get("code")
+    if code != "" && auth.WebFlowEnabled() {
+        slog.InfoContext(r.Context(), "Authorization code received")
+
+        // Reject callbacks that don't carry the state of a login started by this browser
+        browserNonce, _ := getCookieValue(r, loginNonceCookieName)
+        codeVerifier, err := auth.VerifyLoginState(query.Get("state"), browserNonce)
         if err != nil {
-            log.Printf("Error exchanging code for token: %v", err)
-            http.Error(w, "Failed to authenticate with Salesforce", http.StatusInternalServerError)
+            slog.WarnContext(r.Context(), "Rejected login callback", "error", err)
+            message := "This login link is invalid or has expired. Please start the login again."
+            if errors.Is(err, auth.ErrInvalidLoginState) && browserNonce == "" {
+                message = "This login was not started from this browser. Please start the login again."
+            }
+            renderAuthError(w, message, http.StatusBadRequest)
             return
         }
 
-        entID, err := auth.GetUserInfo()  // Get user info using the auth package
+        // The nonce is single-use
+        clearCookie(w, loginNonceCookieName)
+
+        // Start a new session for this user
+        sessionID, err := auth.NewSession()
+        if err != nil {
+            slog.ErrorContext(r.Context(), "Error creating session", "error", err)
+            http.Error(w, "Failed to create session", http.StatusInternalServerError)
+            return
+        }
+
+        err = auth.ExchangeOrRefreshToken(r.Context(), sessionID, code, codeVerifier, false)  // Use the auth package to exchange tokens
         if err != nil {
+            slog.ErrorContext(r.Context(), "Error exchanging code for token", "error", err)
+            http.Error(w, "Failed to authenticate with Salesforce", http.StatusInternalServerError)
+            return
+        }
+
+        if _, err := auth.GetUserInfo(r.Context(), sessionID); err != nil {  // Get user info using the auth package
             http.Error(w, "Failed to get user info", http.StatusInternalServerError)
             return
         }
 
-        // Store entID in cookies with SameSite and Secure attributes
-        http.SetCookie(w, &http.Cookie{
-            Name:     "entID",
-            Value:    entID,
-            Path:     "/",
-            HttpOnly: true,                      // Ensures the cookie is only accessible through HTTP(S)
-            Secure:   true,                      // Ensures the cookie is only sent over HTTPS
-            SameSite: http.SameSiteNoneMode,     // Allows the cookie to be sent in cross-site requests (e.g., iframes)
-        })
+        // Store the signed identity in the session cookie, replacing the legacy plain entID cookie
+        if err := setSessionCookie(w, sessionID); err != nil {
+            slog.ErrorContext(r.Context(), "Error creating session cookie", "error", err)
+            http.Error(w, "Failed to create session", http.StatusInternalServerError)
+            return
+        }
+        clearCookie(w, "entID")
 
         // Redirect back to the homepage after authentication
         http.Redirect(w, r, "/", http.StatusFound)
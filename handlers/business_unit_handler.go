@@ -0,0 +1,107 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+
+    "asset_relationship_finder/auth"
+    "asset_relationship_finder/services"
+)
+
+// ---- Request and Response Structs ----
+
+type BusinessUnitsResponse struct {
+    CurrentMID    string                  `json:"currentMID"`
+    BusinessUnits []services.BusinessUnit `json:"businessUnits"`
+}
+
+type SelectBusinessUnitRequest struct {
+    MID string `json:"mid"`
+}
+
+// ---- Business Unit Handlers ----
+
+// BusinessUnitsHandler lists the business units the user can access and marks the active one
+func BusinessUnitsHandler(w http.ResponseWriter, r *http.Request) {
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+    sessionID := identity.SessionID
+
+    businessUnits, err := services.GetBusinessUnits(r.Context(), sessionID)
+    if err != nil && !errors.Is(err, services.ErrTruncated) {
+        slog.ErrorContext(r.Context(), "Error fetching business units", "error", err)
+        handleServiceError(w, "Failed to retrieve business units", err)
+        return
+    }
+
+    sendJSONResponse(w, BusinessUnitsResponse{
+        CurrentMID:    auth.GetMID(sessionID),
+        BusinessUnits: businessUnits,
+    })
+}
+
+// SelectBusinessUnitHandler switches the session to another business unit by requesting a token for its MID
+func SelectBusinessUnitHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        handleError(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+    sessionID := identity.SessionID
+
+    var req SelectBusinessUnitRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MID == "" {
+        handleError(w, "Invalid request payload", http.StatusBadRequest)
+        return
+    }
+
+    // Only allow business units the user can actually access
+    businessUnits, err := services.GetBusinessUnits(r.Context(), sessionID)
+    if err != nil && !errors.Is(err, services.ErrTruncated) {
+        slog.ErrorContext(r.Context(), "Error fetching business units", "error", err)
+        handleServiceError(w, "Failed to retrieve business units", err)
+        return
+    }
+
+    accessible := false
+    for _, businessUnit := range businessUnits {
+        if businessUnit.ID == req.MID {
+            accessible = true
+            break
+        }
+    }
+    if !accessible {
+        handleError(w, "Business unit not accessible", http.StatusForbidden)
+        return
+    }
+
+    if err := auth.SwitchBusinessUnit(r.Context(), sessionID, req.MID); err != nil {
+        slog.ErrorContext(r.Context(), "Error switching business unit", "mid", req.MID, "error", err)
+        handleError(w, "Failed to switch business unit", http.StatusBadGateway)
+        return
+    }
+
+    // Re-issue the session cookie so it carries the new MID
+    if sessionID != auth.ServerSessionID {
+        if err := setSessionCookie(w, sessionID); err != nil {
+            slog.ErrorContext(r.Context(), "Error re-issuing session cookie", "error", err)
+            handleError(w, "Failed to update session", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    sendJSONResponse(w, BusinessUnitsResponse{
+        CurrentMID:    auth.GetMID(sessionID),
+        BusinessUnits: businessUnits,
+    })
+}
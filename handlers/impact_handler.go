@@ -0,0 +1,156 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "asset_relationship_finder/services"
+)
+
+// ---- Impact Analysis ----
+
+// Hops walked when the depth isn't given, and the most a request may ask for
+const (
+    defaultImpactDepth = 3
+    maxImpactDepth     = 10
+)
+
+// APIImpactResponse is the body of GET /api/v1/assets/{type}/{id}/impact
+type APIImpactResponse struct {
+    Asset      APIAsset          `json:"asset"`
+    Depth      int               `json:"depth"`
+    IndexedAt  time.Time         `json:"indexedAt"`            // When the business unit was crawled
+    Incomplete map[string]string `json:"incomplete,omitempty"` // Asset types the crawl couldn't read in full, their assets may be missing
+    Totals     map[string]int    `json:"totals"`               // Affected assets by type
+    Hops       []APIImpactHop    `json:"hops"`
+}
+
+// APIImpactHop holds the assets affected at one distance from the asset, by type
+type APIImpactHop struct {
+    Distance int                           `json:"distance"`
+    Assets   map[string][]APIImpactedAsset `json:"assets"`
+}
+
+// APIImpactedAsset is an affected asset with the references connecting it to the asset, starting from the asset
+type APIImpactedAsset struct {
+    Type string          `json:"type"`
+    ID   string          `json:"id"`
+    Name string          `json:"name,omitempty"`
+    Path []APIImpactStep `json:"path"`
+}
+
+// APIImpactStep is one reference on a path, From references To in the way Edge says, e.g. a query reads a Data Extension
+type APIImpactStep struct {
+    From APIAsset `json:"from"`
+    Edge string   `json:"edge"`
+    To   APIAsset `json:"to"`
+}
+
+// AssetImpactV1 serves GET /api/v1/assets/{type}/{id}/impact?depth=...&by=..., the assets a change to the asset may
+// break, found in the account index
+func AssetImpactV1(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet) {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+
+    typeName, id := r.PathValue("type"), r.PathValue("id")
+    assetType, by, _, err := parseAssetQuery(typeName, r.URL.Query().Get("by"), "")
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+    depth, err := parseDepth(r.URL.Query().Get("depth"), defaultImpactDepth, maxImpactDepth)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    // The walk needs every asset of the business unit, so it's only answered from the index
+    if !services.IndexEnabled() {
+        writeAPIError(w, http.StatusServiceUnavailable, "index_disabled", "Impact analysis needs the account index, which is turned off by INDEX_ENABLED.")
+        return
+    }
+    graph := services.FreshIndex(identity.SessionID)
+    if graph == nil {
+        w.Header().Set("Retry-After", "60")
+        writeAPIError(w, http.StatusServiceUnavailable, "index_building", "The business unit is being indexed, please try again in a few minutes.")
+        return
+    }
+
+    source, _, err := assetType.resolve(ctx, identity, id, by)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    ref, mustExist := assetType.graphRef(source)
+    if source.Shared || (mustExist && graph.Asset(ref) == nil) {
+        message := fmt.Sprintf("The asset isn't in the account index built at %s, it may have been created since.", graph.BuiltAt.Format(time.RFC3339))
+        if source.Shared {
+            message = "Shared Data Extensions belong to the parent business unit, which isn't indexed."
+        }
+        writeAPIError(w, http.StatusNotFound, "not_indexed", message)
+        return
+    }
+
+    name := source.Name
+    if asset := graph.Asset(ref); asset != nil && name == "" {
+        name = asset.Name
+    }
+    sendJSONResponse(w, newAPIImpactResponse(graph, typeName, id, name, depth, graph.Impact(ref, depth)))
+}
+
+// Helper function to group the affected assets by distance and type
+func newAPIImpactResponse(graph *services.Graph, typeName, id, name string, depth int, impacted []services.ImpactedAsset) APIImpactResponse {
+    response := APIImpactResponse{
+        Asset:     APIAsset{Type: typeName, ID: id, Name: name},
+        Depth:     depth,
+        IndexedAt: graph.BuiltAt,
+        Totals:    make(map[string]int),
+        Hops:      []APIImpactHop{},
+    }
+    for assetType, reason := range graph.Incomplete {
+        if response.Incomplete == nil {
+            response.Incomplete = make(map[string]string)
+        }
+        response.Incomplete[string(assetType)] = reason
+    }
+
+    // Impact returns the assets nearest first, so each distance is one run of them
+    for _, asset := range impacted {
+        if len(response.Hops) == 0 || response.Hops[len(response.Hops)-1].Distance != asset.Distance {
+            response.Hops = append(response.Hops, APIImpactHop{Distance: asset.Distance, Assets: make(map[string][]APIImpactedAsset)})
+        }
+        hop := &response.Hops[len(response.Hops)-1]
+
+        path := make([]APIImpactStep, 0, len(asset.Path))
+        for _, edge := range asset.Path {
+            path = append(path, APIImpactStep{From: graphAsset(graph, edge.From), Edge: string(edge.Type), To: graphAsset(graph, edge.To)})
+        }
+
+        assetType := string(asset.Type)
+        hop.Assets[assetType] = append(hop.Assets[assetType], APIImpactedAsset{Type: assetType, ID: asset.ID, Name: asset.Name, Path: path})
+        response.Totals[assetType]++
+    }
+    return response
+}
+
+// Helper function to name an asset of the graph, only its type and ID are known when the crawl didn't find it
+func graphAsset(graph *services.Graph, ref services.AssetRef) APIAsset {
+    asset := APIAsset{Type: string(ref.Type), ID: ref.ID}
+    if found := graph.Asset(ref); found != nil {
+        asset.Name = found.Name
+    }
+    return asset
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+
+    "asset_relationship_finder/jobs"
+)
+
+// ---- Lookup Jobs ----
+
+// JobRequest is the body of POST /jobs, it names the asset like the v1 relationships route does
+type JobRequest struct {
+    Type    string `json:"type"`    // data-extension, cloud-page, email, query, import, script or filter
+    ID      string `json:"id"`
+    By      string `json:"by"`      // What the ID holds, the asset type's default when empty
+    Include string `json:"include"` // Comma separated relationships, all of them when empty
+}
+
+// JobResponse tells where a job is at, a failed job also carries the error its result is answered with
+type JobResponse struct {
+    jobs.Status
+    Error     *APIError `json:"error,omitempty"`
+    ResultURL string    `json:"resultUrl,omitempty"`
+}
+
+// JobsHandler serves POST /jobs, which queues a relationship lookup that may run longer than a request may take
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodPost) {
+        return
+    }
+
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+
+    var req JobRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_request", "The request body must be a JSON job request.")
+        return
+    }
+    if req.ID == "" {
+        writeAPIError(w, http.StatusBadRequest, "invalid_parameter", "The id of the asset is required.")
+        return
+    }
+
+    // Reject a bad request now rather than in a job that fails later
+    assetType, by, selection, err := parseAssetQuery(req.Type, req.By, req.Include)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    job, err := jobs.Submit(r.Context(), identity.SessionID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+        progress.SetTotal(len(selection))
+
+        source, cacheKey, err := assetType.resolve(ctx, identity, req.ID, by)
+        if err != nil {
+            return nil, err
+        }
+
+        response, err := collectRelationships(ctx, assetType.sourceType, source, selection, cacheKey, func(RelationshipSection) {
+            progress.TaskDone()
+        })
+        if err != nil {
+            return nil, err
+        }
+        return newAPIRelationshipsResponse(req.Type, req.ID, response), nil
+    })
+    if errors.Is(err, jobs.ErrQueueFull) {
+        w.Header().Set("Retry-After", "30")
+        writeAPIError(w, http.StatusServiceUnavailable, "queue_full", "Too many lookups are waiting, please try again shortly.")
+        return
+    }
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    status := job.Status()
+    w.Header().Set("Location", "/jobs/"+status.ID)
+    sendJobResponse(w, http.StatusAccepted, status, nil)
+}
+
+// JobHandler serves GET /jobs/{id} with the job's progress, and DELETE /jobs/{id} to cancel it
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+        return
+    }
+
+    job, ok := ownJob(w, r)
+    if !ok {
+        return
+    }
+
+    if r.Method == http.MethodDelete {
+        job.Cancel()
+    }
+
+    _, _, err := job.Result()
+    sendJobResponse(w, http.StatusOK, job.Status(), err)
+}
+
+// JobResultHandler serves GET /jobs/{id}/result, the relationships found once the job has succeeded or timed out. The
+// result of a timed out job is partial, its unfinished sections have the status timeout, and answered with the timeout
+// error when it was cut off before it had one.
+func JobResultHandler(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet) {
+        return
+    }
+
+    job, ok := ownJob(w, r)
+    if !ok {
+        return
+    }
+
+    state, result, err := job.Result()
+    switch state {
+    case jobs.StateSucceeded:
+        sendJSONResponse(w, result)
+    case jobs.StateTimedOut:
+        if result == nil {
+            writeAPIServiceError(w, err)
+            return
+        }
+        sendJSONResponse(w, result)
+    case jobs.StateFailed:
+        writeAPIServiceError(w, err)
+    case jobs.StateCanceled:
+        writeAPIError(w, http.StatusConflict, "job_canceled", "The job was canceled before it finished.")
+    default:
+        writeAPIError(w, http.StatusConflict, "job_not_finished", fmt.Sprintf("The job is %s, poll /jobs/%s until it has succeeded.", state, r.PathValue("id")))
+    }
+}
+
+// Helper function to find the session's job named in the path, answering with a 404 when there's none
+func ownJob(w http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return nil, false
+    }
+
+    job, err := jobs.Get(identity.SessionID, r.PathValue("id"))
+    if err != nil {
+        writeAPIError(w, http.StatusNotFound, "job_not_found", "No job found with this ID, it may have expired.")
+        return nil, false
+    }
+    return job, true
+}
+
+// Helper function to send a job's status, with the error of a failed job
+func sendJobResponse(w http.ResponseWriter, statusCode int, status jobs.Status, err error) {
+    response := JobResponse{Status: status}
+    switch status.State {
+    case jobs.StateSucceeded, jobs.StateTimedOut:
+        response.ResultURL = "/jobs/" + status.ID + "/result"
+    case jobs.StateFailed:
+        _, body := apiServiceError(err)
+        response.Error = &body
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(statusCode)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        slog.Error("Error encoding job response", "error", err)
+    }
+}
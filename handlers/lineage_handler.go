@@ -0,0 +1,592 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "asset_relationship_finder/services"
+)
+
+// ---- Data Lineage ----
+
+// Writer hops walked when the depth isn't given, the most a request may ask for, and the nodes after which the walk
+// stops going further up
+const (
+    defaultLineageDepth = 5
+    maxLineageDepth     = 10
+    maxLineageNodes     = 200
+)
+
+// Node types of a lineage that aren't asset types of the index
+const (
+    lineageFile     = "file"
+    lineageList     = "list"
+    lineageDataView = "dataView"
+)
+
+// Why the walk goes no further up from a node
+const (
+    originFile         = "file"         // The file an import reads
+    originSynchronized = "synchronized" // Synchronized from Salesforce CRM by Marketing Cloud Connect
+    originDataView     = "dataView"     // A system data view holding tracking data
+    originList         = "list"         // A list a filter activity filters
+    originNoWriters    = "noWriters"    // Nothing writes to the Data Extension, its rows come from elsewhere, e.g. the API
+    originNotFound     = "notFound"     // A table a query reads that isn't a Data Extension of the business unit
+    originDepthLimit   = "depthLimit"   // Not looked up, the walk reached its depth or node limit
+)
+
+// Edge types of a lineage, edges point the way the data flows
+const (
+    lineageSource = "source" // To reads from From
+    lineageTarget = "target" // From writes to To
+)
+
+// APILineageResponse is the body of GET /api/v1/assets/data-extension/{id}/lineage
+type APILineageResponse struct {
+    Asset     APIAsset          `json:"asset"`
+    Depth     int               `json:"depth"`
+    Truncated bool              `json:"truncated"` // Some writers may be missing, see the depthLimit nodes and errors
+    Nodes     []*APILineageNode `json:"nodes"`
+    Edges     []APILineageEdge  `json:"edges"`
+}
+
+// APILineageNode is an asset the data passes through. Level counts the steps up from the Data Extension looked up, so
+// Data Extensions are on even levels and the writers between them on odd ones.
+type APILineageNode struct {
+    ID       string        `json:"id"`
+    Type     string        `json:"type"`
+    AssetID  string        `json:"assetId,omitempty"`
+    Name     string        `json:"name"`
+    Key      string        `json:"key,omitempty"`
+    Location string        `json:"location,omitempty"` // CustomerKey of the file location an import reads from
+    Level    int           `json:"level"`
+    Origin   string        `json:"origin,omitempty"`
+    Error    *SectionError `json:"error,omitempty"`
+}
+
+// APILineageEdge is a step of the data, Cycle marks the edges closing a loop, e.g. a query updating the Data Extension
+// it reads, so the others form a DAG
+type APILineageEdge struct {
+    From  string `json:"from"`
+    To    string `json:"to"`
+    Type  string `json:"type"`
+    Cycle bool   `json:"cycle,omitempty"`
+}
+
+// DataExtensionLineageV1 serves GET /api/v1/assets/data-extension/{id}/lineage?depth=...&by=..., where the data of
+// the Data Extension comes from
+func DataExtensionLineageV1(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet) {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    identity, ok := identityFromContext(r.Context())
+    if !ok {
+        writeUnauthenticated(w, nil)
+        return
+    }
+
+    id := r.PathValue("id")
+    assetType, by, _, err := parseAssetQuery("data-extension", r.URL.Query().Get("by"), "")
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+    depth, err := parseDepth(r.URL.Query().Get("depth"), defaultLineageDepth, maxLineageDepth)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    source, _, err := assetType.resolve(ctx, identity, id, by)
+    if err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    walk := newLineageWalk(identity.SessionID, identity.EnterpriseID)
+    if err := walk.run(ctx, source, depth); err != nil {
+        writeAPIServiceError(w, err)
+        return
+    }
+
+    sendJSONResponse(w, APILineageResponse{
+        Asset:     APIAsset{Type: "data-extension", ID: id, Name: source.Name},
+        Depth:     depth,
+        Truncated: walk.truncated,
+        Nodes:     walk.sortedNodes(),
+        Edges:     walk.sortedEdges(),
+    })
+}
+
+// lineageWalk follows the writers of Data Extensions backwards, one writer hop at a time. The Data Extensions of a hop
+// are looked up concurrently, so everything they share is guarded by mu.
+type lineageWalk struct {
+    sessionID    string
+    enterpriseID string
+
+    mu        sync.Mutex
+    nodes     map[string]*APILineageNode
+    edges     map[APILineageEdge]bool
+    tables    map[string]*lineageTable // Tables queries read by lowercased name, so each one is looked up once
+    next      []lineageDataExtension     // Data Extensions found in this hop, looked up in the next one
+    truncated bool
+    authErr   error
+}
+
+// lineageTable is a table queries read, looked up by the first query that needs it while the others wait for it
+type lineageTable struct {
+    once sync.Once
+    node *APILineageNode
+}
+
+// lineageDataExtension is a Data Extension whose writers are still to be looked up
+type lineageDataExtension struct {
+    node       *APILineageNode
+    objectID   string
+    name       string
+    categoryID string
+    shared     bool
+}
+
+func newLineageWalk(sessionID, enterpriseID string) *lineageWalk {
+    return &lineageWalk{
+        sessionID:    sessionID,
+        enterpriseID: enterpriseID,
+        nodes:        make(map[string]*APILineageNode),
+        edges:        make(map[APILineageEdge]bool),
+        tables:       make(map[string]*lineageTable),
+    }
+}
+
+// run walks up to depth writer hops from the Data Extension, the error is only set when SFMC rejected the token
+func (w *lineageWalk) run(ctx context.Context, source AssetSource, depth int) error {
+    w.addDataExtension(services.DataExtension{
+        Name:        source.Name,
+        CustomerKey: source.CustomerKey,
+        ObjectID:    source.ObjectID,
+        CategoryID:  source.CategoryID,
+    }, source.Shared, 0)
+
+    for hop := 0; len(w.next) > 0; hop++ {
+        frontier := w.next
+        w.next = nil
+
+        if hop >= depth || len(w.nodes) >= maxLineageNodes {
+            for _, dataExtension := range frontier {
+                dataExtension.node.Origin = originDepthLimit
+            }
+            w.truncated = true
+            break
+        }
+
+        var wg sync.WaitGroup
+        for _, dataExtension := range frontier {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                w.expand(ctx, dataExtension)
+            }()
+        }
+        wg.Wait()
+    }
+
+    w.markCycles()
+    return w.authErr
+}
+
+// expand adds the queries, imports and filters writing to the Data Extension along with what they read
+func (w *lineageWalk) expand(ctx context.Context, dataExtension lineageDataExtension) {
+    node := dataExtension.node
+
+    // Synchronized Data Extensions are only written by Marketing Cloud Connect, shared ones are never synchronized
+    if !dataExtension.shared && dataExtension.categoryID != "" {
+        synchronized, err := services.IsSynchronizedFolder(ctx, w.sessionID, dataExtension.categoryID)
+        if w.lookupFailed(err, node) {
+            return
+        }
+        if synchronized {
+            w.setOrigin(node, originSynchronized)
+            return
+        }
+    }
+
+    queries, err := fetchQueriesTargeting(ctx, w.sessionID, dataExtension.name)
+    if w.lookupFailed(err, node) {
+        return
+    }
+    imports, err := fetchImportsForDE(ctx, w.sessionID, dataExtension.objectID)
+    if w.lookupFailed(err, node) {
+        return
+    }
+    filters, err := fetchFilters(ctx, w.sessionID, dataExtension.objectID)
+    if w.lookupFailed(err, node) {
+        return
+    }
+
+    if len(queries)+len(imports)+len(filters) == 0 {
+        w.setOrigin(node, originNoWriters)
+        return
+    }
+
+    w.expandQueries(ctx, node, queries)
+    w.expandImports(ctx, node, imports)
+    w.expandFilters(ctx, node, filters)
+}
+
+// expandQueries adds the queries writing to the Data Extension and the tables named in their SQL
+func (w *lineageWalk) expandQueries(ctx context.Context, target *APILineageNode, queries []services.QueryDefinition) {
+    if len(queries) == 0 {
+        return
+    }
+
+    // Writers left out at the node limit aren't looked up any further
+    var added []services.QueryDefinition
+    var queryNodes []*APILineageNode
+    var objectIDs []string
+    for _, query := range queries {
+        if queryNode := w.addWriter(services.AssetQuery, query.ObjectID, query.Name, target); queryNode != nil {
+            added = append(added, query)
+            queryNodes = append(queryNodes, queryNode)
+            objectIDs = append(objectIDs, query.ObjectID)
+        }
+    }
+    if len(added) == 0 {
+        return
+    }
+
+    texts, err := services.GetQueryTexts(ctx, w.sessionID, objectIDs)
+    if w.lookupFailed(err, queryNodes...) {
+        return
+    }
+
+    for i, query := range added {
+        for _, name := range services.QuerySourceNames(texts[query.ObjectID]) {
+            w.addEdge(w.table(ctx, name, target.Level+2), queryNodes[i], lineageSource)
+        }
+    }
+}
+
+// expandImports adds the imports writing to the Data Extension and the files they read
+func (w *lineageWalk) expandImports(ctx context.Context, target *APILineageNode, imports []services.ImportDefinition) {
+    if len(imports) == 0 {
+        return
+    }
+
+    var added []services.ImportDefinition
+    var importNodes []*APILineageNode
+    var objectIDs []string
+    for _, importDefinition := range imports {
+        if importNode := w.addWriter(services.AssetImport, importDefinition.ObjectID, importDefinition.Name, target); importNode != nil {
+            added = append(added, importDefinition)
+            importNodes = append(importNodes, importNode)
+            objectIDs = append(objectIDs, importDefinition.ObjectID)
+        }
+    }
+    if len(added) == 0 {
+        return
+    }
+
+    sources, err := services.GetImportSources(ctx, w.sessionID, objectIDs)
+    if w.lookupFailed(err, importNodes...) {
+        return
+    }
+
+    for i, importDefinition := range added {
+        source, found := sources[importDefinition.ObjectID]
+        if !found || (source.FileSpec == "" && source.LocationKey == "") {
+            continue
+        }
+        file, _ := w.addNode(APILineageNode{
+            ID:       lineageFile + ":" + source.LocationKey + "/" + source.FileSpec,
+            Type:     lineageFile,
+            Name:     source.FileSpec,
+            Location: source.LocationKey,
+            Level:    target.Level + 2,
+            Origin:   originFile,
+        })
+        w.addEdge(file, importNodes[i], lineageSource)
+    }
+}
+
+// expandFilters adds the filter activities writing to the Data Extension and what they filter
+func (w *lineageWalk) expandFilters(ctx context.Context, target *APILineageNode, filters []services.FilterActivity) {
+    for _, filter := range filters {
+        filterNode := w.addWriter(services.AssetFilter, filter.ObjectID, filter.Name, target)
+        if filterNode == nil {
+            continue
+        }
+
+        source, err := services.GetFilterSource(ctx, w.sessionID, filter.ObjectID)
+        if w.lookupFailed(err, filterNode) {
+            continue
+        }
+
+        // Source type 2 is a Data Extension, the others are lists
+        if source.SourceTypeID != 2 {
+            list, _ := w.addNode(APILineageNode{
+                ID:      lineageList + ":" + source.SourceObjectID,
+                Type:    lineageList,
+                AssetID: source.SourceObjectID,
+                Level:   target.Level + 2,
+                Origin:  originList,
+            })
+            w.addEdge(list, filterNode, lineageSource)
+            continue
+        }
+
+        dataExtension, err := services.GetDataExtensionByObjectID(ctx, w.sessionID, source.SourceObjectID)
+        if w.lookupFailed(err, filterNode) {
+            continue
+        }
+        if dataExtension == nil {
+            missing, _ := w.addNode(APILineageNode{
+                ID:      string(services.AssetDataExtension) + ":" + source.SourceObjectID,
+                Type:    string(services.AssetDataExtension),
+                AssetID: source.SourceObjectID,
+                Level:   target.Level + 2,
+                Origin:  originNotFound,
+            })
+            w.addEdge(missing, filterNode, lineageSource)
+            continue
+        }
+        w.addEdge(w.addDataExtension(*dataExtension, false, target.Level+2), filterNode, lineageSource)
+    }
+}
+
+// table returns the node of a table a query reads, looking it up by name the first time it's seen
+func (w *lineageWalk) table(ctx context.Context, name string, level int) *APILineageNode {
+    key := strings.ToLower(name)
+    w.mu.Lock()
+    table, found := w.tables[key]
+    if !found {
+        table = &lineageTable{}
+        w.tables[key] = table
+    }
+    w.mu.Unlock()
+
+    table.once.Do(func() {
+        if services.SystemDataView(name) {
+            table.node, _ = w.addNode(APILineageNode{ID: lineageDataView + ":" + key, Type: lineageDataView, Name: name, Level: level, Origin: originDataView})
+        } else {
+            table.node = w.lookupTable(ctx, name, level)
+        }
+    })
+    return table.node
+}
+
+// lookupTable finds the Data Extension a query reads, shared ones are named with the ENT. prefix
+func (w *lineageWalk) lookupTable(ctx context.Context, name string, level int) *APILineageNode {
+    lookupName := name
+    if len(name) > 4 && strings.EqualFold(name[:4], "ent.") {
+        lookupName = name[4:]
+    }
+
+    req := DataExtensionRequest{Name: lookupName}
+    dataExtensions, shared, err := fetchDataExtensions(ctx, w.sessionID, buildFilterFromRequest(req), w.enterpriseID, req)
+    if err == nil && len(dataExtensions) > 0 {
+        return w.addDataExtension(dataExtensions[0], shared, level)
+    }
+
+    node, _ := w.addNode(APILineageNode{
+        ID:    string(services.AssetDataExtension) + ":name:" + strings.ToLower(name),
+        Type:  string(services.AssetDataExtension),
+        Name:  name,
+        Level: level,
+    })
+    if !w.lookupFailed(err, node) {
+        w.setOrigin(node, originNotFound)
+    }
+    return node
+}
+
+// addDataExtension adds the Data Extension, its writers are looked up in the next hop if it's new. It's nil when the
+// walk reached its node limit.
+func (w *lineageWalk) addDataExtension(dataExtension services.DataExtension, shared bool, level int) *APILineageNode {
+    node, added := w.addNode(APILineageNode{
+        ID:      string(services.AssetDataExtension) + ":" + dataExtension.ObjectID,
+        Type:    string(services.AssetDataExtension),
+        AssetID: dataExtension.ObjectID,
+        Name:    dataExtension.Name,
+        Key:     dataExtension.CustomerKey,
+        Level:   level,
+    })
+    if added {
+        w.mu.Lock()
+        w.next = append(w.next, lineageDataExtension{
+            node:       node,
+            objectID:   dataExtension.ObjectID,
+            name:       dataExtension.Name,
+            categoryID: dataExtension.CategoryID,
+            shared:     shared,
+        })
+        w.mu.Unlock()
+    }
+    return node
+}
+
+// addWriter adds a query, import or filter activity writing to the target, it's nil when the walk reached its node
+// limit
+func (w *lineageWalk) addWriter(assetType services.AssetType, objectID, name string, target *APILineageNode) *APILineageNode {
+    node, _ := w.addNode(APILineageNode{
+        ID:      string(assetType) + ":" + objectID,
+        Type:    string(assetType),
+        AssetID: objectID,
+        Name:    name,
+        Level:   target.Level + 1,
+    })
+    w.addEdge(node, target, lineageTarget)
+    return node
+}
+
+// addNode adds the node unless there's one with its ID already, the node kept is returned either way. Once the walk
+// holds maxLineageNodes no node is added, nil is returned and the lineage is marked truncated.
+func (w *lineageWalk) addNode(node APILineageNode) (*APILineageNode, bool) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if existing, found := w.nodes[node.ID]; found {
+        return existing, false
+    }
+    if len(w.nodes) >= maxLineageNodes {
+        w.truncated = true
+        return nil, false
+    }
+    w.nodes[node.ID] = &node
+    return &node, true
+}
+
+// addEdge links the nodes, a node left out at the node limit has no edges
+func (w *lineageWalk) addEdge(from, to *APILineageNode, edgeType string) {
+    if from == nil || to == nil {
+        return
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.edges[APILineageEdge{From: from.ID, To: to.ID, Type: edgeType}] = true
+}
+
+func (w *lineageWalk) setOrigin(node *APILineageNode, origin string) {
+    if node == nil {
+        return
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    node.Origin = origin
+}
+
+// lookupFailed records a failed lookup on the nodes it was made for, a truncated one still returned results to use
+func (w *lineageWalk) lookupFailed(err error, nodes ...*APILineageNode) bool {
+    if err == nil {
+        return false
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    w.truncated = true
+    if errors.Is(err, services.ErrTruncated) {
+        return false
+    }
+    if errors.Is(err, services.ErrAuthExpired) {
+        w.authErr = err
+    }
+    code, message := sectionErrorDetails(err)
+    for _, node := range nodes {
+        if node != nil {
+            node.Error = &SectionError{Code: code, Message: message}
+        }
+    }
+    return true
+}
+
+// markCycles flags the edges leading back to a node the data already passed through, found by a depth-first search
+func (w *lineageWalk) markCycles() {
+    outgoing := make(map[string][]APILineageEdge)
+    for edge := range w.edges {
+        outgoing[edge.From] = append(outgoing[edge.From], edge)
+    }
+    for _, edges := range outgoing {
+        sortLineageEdges(edges)
+    }
+
+    const (
+        unvisited = iota
+        visiting
+        visited
+    )
+    state := make(map[string]int)
+    var backEdges []APILineageEdge
+
+    var visit func(id string)
+    visit = func(id string) {
+        state[id] = visiting
+        for _, edge := range outgoing[id] {
+            switch state[edge.To] {
+            case unvisited:
+                visit(edge.To)
+            case visiting:
+                backEdges = append(backEdges, edge)
+            }
+        }
+        state[id] = visited
+    }
+    for _, node := range w.sortedNodes() {
+        if state[node.ID] == unvisited {
+            visit(node.ID)
+        }
+    }
+
+    for _, edge := range backEdges {
+        delete(w.edges, edge)
+        edge.Cycle = true
+        w.edges[edge] = true
+    }
+}
+
+// sortedNodes returns the nodes level by level, so the result is the same whatever order the lookups finished in
+func (w *lineageWalk) sortedNodes() []*APILineageNode {
+    nodes := make([]*APILineageNode, 0, len(w.nodes))
+    for _, node := range w.nodes {
+        nodes = append(nodes, node)
+    }
+    sort.Slice(nodes, func(i, j int) bool {
+        if nodes[i].Level != nodes[j].Level {
+            return nodes[i].Level < nodes[j].Level
+        }
+        if nodes[i].Type != nodes[j].Type {
+            return nodes[i].Type < nodes[j].Type
+        }
+        if nodes[i].Name != nodes[j].Name {
+            return nodes[i].Name < nodes[j].Name
+        }
+        return nodes[i].ID < nodes[j].ID
+    })
+    return nodes
+}
+
+func (w *lineageWalk) sortedEdges() []APILineageEdge {
+    edges := make([]APILineageEdge, 0, len(w.edges))
+    for edge := range w.edges {
+        edges = append(edges, edge)
+    }
+    sortLineageEdges(edges)
+    return edges
+}
+
+func sortLineageEdges(edges []APILineageEdge) {
+    sort.Slice(edges, func(i, j int) bool {
+        if edges[i].From != edges[j].From {
+            return edges[i].From < edges[j].From
+        }
+        return edges[i].To < edges[j].To
+    })
+}
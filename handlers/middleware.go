@@ -0,0 +1,168 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+
+    "asset_relationship_finder/auth"
+    "asset_relationship_finder/logging"
+    "asset_relationship_finder/services"
+)
+
+// Key under which the verified identity is stored in the request context
+type contextKey string
+
+const identityContextKey contextKey = "identity"
+
+// Paths that are reachable without a session: static files, the OAuth routes, the OpenAPI document and the home page (which is also the OAuth callback)
+var publicPathPrefixes = []string{"/static/", "/auth/", "/api/v1/openapi.json"}
+
+// ---- Error Structs ----
+
+// APIError is the structured error body returned by the middleware
+type APIError struct {
+    Code     string `json:"code"`
+    Message  string `json:"message"`
+    LoginURL string `json:"loginUrl,omitempty"`
+}
+
+type APIErrorResponse struct {
+    Error APIError `json:"error"`
+}
+
+// ---- Middleware ----
+
+// statusRecorder remembers the status code written by the wrapped handler
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// RequestLogger tags every request with an ID, reusing Heroku's X-Request-ID when present, and logs its outcome
+func RequestLogger(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requestID := r.Header.Get("X-Request-ID")
+        if requestID == "" {
+            requestID = logging.NewRequestID()
+        }
+        w.Header().Set("X-Request-ID", requestID)
+
+        ctx := logging.WithRequestID(r.Context(), requestID)
+        recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        start := time.Now()
+
+        next.ServeHTTP(recorder, r.WithContext(ctx))
+
+        slog.InfoContext(ctx, "Request completed",
+            "method", r.Method,
+            "path", r.URL.Path,
+            "status", recorder.status,
+            "duration_ms", time.Since(start).Milliseconds(),
+        )
+    })
+}
+
+// RequireSession wraps the handler mux and rejects every non-public request that doesn't carry a valid session
+func RequireSession(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if isPublicPath(r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        identity, err := getIdentity(r)
+        if err != nil {
+            slog.WarnContext(r.Context(), "Rejected unauthenticated request", "path", r.URL.Path, "error", err)
+            writeUnauthenticated(w, err)
+            return
+        }
+
+        ctx := context.WithValue(r.Context(), identityContextKey, identity)
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+// isPublicPath reports whether the path can be served without a session
+func isPublicPath(path string) bool {
+    if path == "/" {
+        return true
+    }
+    for _, prefix := range publicPathPrefixes {
+        if strings.HasPrefix(path, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// identityFromContext returns the identity stored by RequireSession
+func identityFromContext(ctx context.Context) (*auth.Identity, bool) {
+    identity, ok := ctx.Value(identityContextKey).(*auth.Identity)
+    return identity, ok && identity != nil
+}
+
+// Helper function to get the verified identity of the user making the request
+func getIdentity(r *http.Request) (*auth.Identity, error) {
+    // Browser users carry their identity in the signed session cookie
+    if cookieValue, err := getCookieValue(r, sessionCookieName); err == nil {
+        identity, err := auth.VerifySessionCookie(cookieValue)
+        if err != nil {
+            return nil, err
+        }
+
+        // A rejected refresh token can't be recovered without a new login
+        if auth.RefreshRejected(identity.SessionID) {
+            return nil, auth.ErrRefreshTokenRejected
+        }
+        return identity, nil
+    }
+
+    // Headless callers present the server API key and share the server-to-server session
+    if auth.ServerToServerEnabled() {
+        apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if auth.ValidServerAPIKey(apiKey) {
+            return auth.ServerIdentity()
+        }
+    }
+
+    return nil, fmt.Errorf("no session found in request")
+}
+
+// writeUnauthenticated sends the structured 401 the frontend uses to redirect to the login page
+func writeUnauthenticated(w http.ResponseWriter, err error) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusUnauthorized)
+    if err := json.NewEncoder(w).Encode(APIErrorResponse{Error: unauthenticatedError(err)}); err != nil {
+        slog.Error("Error encoding error response", "error", err)
+    }
+}
+
+// unauthenticatedError tells the user to log in, or to log in again when their session expired
+func unauthenticatedError(err error) APIError {
+    apiError := APIError{
+        Code:     "unauthenticated",
+        Message:  "Please log in to continue.",
+        LoginURL: "/auth/login",
+    }
+    if errors.Is(err, auth.ErrRefreshTokenRejected) || errors.Is(err, auth.ErrSessionNotFound) || errors.Is(err, auth.ErrInvalidSessionCookie) || errors.Is(err, services.ErrAuthExpired) {
+        apiError.Code = "session_expired"
+        apiError.Message = "Your session has expired. Please log in again."
+    }
+    return apiError
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "reflect"
+    "slices"
+    "sync"
+
+    "github.com/patrickmn/go-cache"
+    "asset_relationship_finder/services"
+)
+
+// ---- Relationship Providers ----
+
+// Asset types relationships are looked up for, matching the asset types the frontend sends
+const (
+    sourceDataExtension = "dataExtension"
+    sourceCloudPage     = "cloudPage"
+    sourceEmail         = "email"
+    sourceActivity      = "activity"
+)
+
+// AssetSource is the asset whose relationships are looked up, with everything the providers need to find them
+type AssetSource struct {
+    SessionID   string
+    ID          string // CloudPage ID or legacy Email ID
+    Name        string
+    CustomerKey string
+    ObjectID    string // Data Extension or activity ObjectID
+    CategoryID  string
+    Shared      bool   // Shared Data Extension, owned by the enterprise
+}
+
+// RelationshipProvider finds the assets related to a source asset in one way, e.g. the queries targeting a Data Extension
+type RelationshipProvider interface {
+    SourceType() string // Asset type the relationship is looked up for
+    Key() string        // Key in the user's selection and in the response
+    Cacheable() bool    // Whether non-empty results may be served from the cache
+    Fetch(ctx context.Context, source AssetSource) (interface{}, error)
+}
+
+// relationshipFunc is a RelationshipProvider backed by a plain function, answered from the account index when it can
+type relationshipFunc struct {
+    sourceType string
+    key        string
+    cacheable  bool
+    fromIndex  indexFunc // Optional
+    fetch      func(ctx context.Context, source AssetSource) (interface{}, error)
+}
+
+func (r relationshipFunc) SourceType() string { return r.sourceType }
+func (r relationshipFunc) Key() string        { return r.key }
+func (r relationshipFunc) Cacheable() bool    { return r.cacheable }
+
+func (r relationshipFunc) Fetch(ctx context.Context, source AssetSource) (interface{}, error) {
+    if r.fromIndex != nil {
+        if graph := services.FreshIndex(source.SessionID); graph != nil {
+            if result, ok := r.fromIndex(graph, source); ok {
+                return indexedResult{result}, nil
+            }
+        }
+    }
+    return r.fetch(ctx, source)
+}
+
+// Registered providers by source type, in registration order
+var (
+    providers      = make(map[string][]RelationshipProvider)
+    providersMutex sync.RWMutex
+)
+
+// RegisterRelationship adds a provider, registering the same key twice for a source type panics
+func RegisterRelationship(provider RelationshipProvider) {
+    providersMutex.Lock()
+    defer providersMutex.Unlock()
+
+    for _, registered := range providers[provider.SourceType()] {
+        if registered.Key() == provider.Key() {
+            panic(fmt.Sprintf("relationship %q already registered for %s", provider.Key(), provider.SourceType()))
+        }
+    }
+    providers[provider.SourceType()] = append(providers[provider.SourceType()], provider)
+}
+
+// Helper function to register a relationship backed by a plain function
+func registerRelationship(sourceType, key string, cacheable bool, fetch func(ctx context.Context, source AssetSource) (interface{}, error)) {
+    RegisterRelationship(relationshipFunc{sourceType: sourceType, key: key, cacheable: cacheable, fetch: fetch})
+}
+
+// Helper function to register a relationship that's answered from the account index, and looked up live when the
+// index is stale or can't tell
+func registerIndexedRelationship(sourceType, key string, cacheable bool, fromIndex indexFunc, fetch func(ctx context.Context, source AssetSource) (interface{}, error)) {
+    RegisterRelationship(relationshipFunc{sourceType: sourceType, key: key, cacheable: cacheable, fromIndex: fromIndex, fetch: fetch})
+}
+
+// relationshipProviders returns the providers registered for the source type
+func relationshipProviders(sourceType string) []RelationshipProvider {
+    providersMutex.RLock()
+    defer providersMutex.RUnlock()
+    return slices.Clone(providers[sourceType])
+}
+
+// Every relationship the finder knows about, adding one only takes a registration here
+func init() {
+    // Data Extension relationships
+    registerRelationship(sourceDataExtension, "dePath", true, func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchPath(ctx, source.SessionID, source.CategoryID, source.Shared)
+    })
+    registerIndexedRelationship(sourceDataExtension, "queriesTargeting", true, indexSources[services.QueryDefinition](dataExtensionRef, services.EdgeTargets, services.AssetQuery), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchQueriesTargeting(ctx, source.SessionID, source.Name)
+    })
+    registerIndexedRelationship(sourceDataExtension, "queriesIncluding", true, indexSources[services.QueryDefinition](dataExtensionRef, services.EdgeReads, services.AssetQuery), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchQueriesIncluding(ctx, source.SessionID, source.Name)
+    })
+    registerIndexedRelationship(sourceDataExtension, "importsTargeting", true, indexSources[services.ImportDefinition](dataExtensionRef, services.EdgeTargets, services.AssetImport), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchImportsForDE(ctx, source.SessionID, source.ObjectID)
+    })
+    registerIndexedRelationship(sourceDataExtension, "filtersTargeting", true, indexSources[services.FilterActivity](dataExtensionRef, services.EdgeTargets, services.AssetFilter), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchFilters(ctx, source.SessionID, source.ObjectID)
+    })
+    registerIndexedRelationship(sourceDataExtension, "contentEmailsIncluding", true, indexSources[services.Email](dataExtensionRef, services.EdgeReads, services.AssetEmail), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetEmails(ctx, source.SessionID, source.Name, "")
+    })
+    registerIndexedRelationship(sourceDataExtension, "initiatedEmailsTargeting", true, indexSources[services.EmailSendDefinition](dataExtensionRef, services.EdgeTargets, services.AssetSendDefinition), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetInitiatedEmails(ctx, source.SessionID, source.ObjectID, "")
+    })
+    registerIndexedRelationship(sourceDataExtension, "journeysUsingDE", true, indexSources[services.Journey](dataExtensionRef, services.EdgeEntrySource, services.AssetJourney), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetJourneys(ctx, source.SessionID, source.Name, "")
+    })
+    registerIndexedRelationship(sourceDataExtension, "scriptsIncluding", true, indexSources[services.Script](dataExtensionRef, services.EdgeReads, services.AssetScript), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetScripts(ctx, source.SessionID, source.Name, source.CustomerKey, "")
+    })
+    registerIndexedRelationship(sourceDataExtension, "pagesIncluding", true, indexSources[services.CloudPage](dataExtensionRef, services.EdgeReads, services.AssetCloudPage), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetCloudPages(ctx, source.SessionID, source.Name, source.CustomerKey, "")
+    })
+
+    // CloudPage relationships
+    registerIndexedRelationship(sourceCloudPage, "emailsUsingCloudPage", false, indexSources[services.Email](cloudPageRef, services.EdgeLinks, services.AssetEmail), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchEmailsUsingCloudPage(ctx, source.SessionID, source.ID)
+    })
+    registerIndexedRelationship(sourceCloudPage, "cloudPagesUsingCloudPage", false, indexSources[services.CloudPage](cloudPageRef, services.EdgeLinks, services.AssetCloudPage), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchCloudPagesUsingCloudPage(ctx, source.SessionID, source.ID)
+    })
+
+    // Email relationships
+    registerIndexedRelationship(sourceEmail, "journeysUsingEmail", false, indexSources[services.Journey](emailRef, services.EdgeSends, services.AssetJourney), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetJourneys(ctx, source.SessionID, "", source.ID)
+    })
+    registerIndexedRelationship(sourceEmail, "initiatedEmailsUsing", false, indexSources[services.EmailSendDefinition](emailRef, services.EdgeSends, services.AssetSendDefinition), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetInitiatedEmails(ctx, source.SessionID, "", source.ID)
+    })
+    registerIndexedRelationship(sourceEmail, "triggeredSends", false, indexSources[services.TriggeredSendDefinition](emailRef, services.EdgeSends, services.AssetTriggeredSend), func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return services.GetTriggeredSends(ctx, source.SessionID, source.ID)
+    })
+
+    // Automation activity relationships
+    registerIndexedRelationship(sourceActivity, "automations", false, indexAutomations, func(ctx context.Context, source AssetSource) (interface{}, error) {
+        return fetchAutomationsForActivity(ctx, source.SessionID, source.ObjectID)
+    })
+}
+
+// ---- Index Answers ----
+
+// indexFunc answers a relationship from the account graph, ok is false when the graph can't tell, e.g. because the
+// source asset was created after the crawl or an asset type couldn't be read in full
+type indexFunc func(graph *services.Graph, source AssetSource) (result interface{}, ok bool)
+
+// indexedResult marks a result answered from the index, so its status says so
+type indexedResult struct {
+    value interface{}
+}
+
+// How a source asset is found in the graph, and whether it must have been crawled for the graph to answer. CloudPages
+// are named by the ID other assets link to them with, which needn't be a crawled asset's ID.
+type sourceRef func(source AssetSource) (ref services.AssetRef, mustExist bool)
+
+func dataExtensionRef(source AssetSource) (services.AssetRef, bool) {
+    return services.AssetRef{Type: services.AssetDataExtension, ID: source.ObjectID}, true
+}
+
+func cloudPageRef(source AssetSource) (services.AssetRef, bool) {
+    return services.AssetRef{Type: services.AssetCloudPage, ID: source.ID}, false
+}
+
+func emailRef(source AssetSource) (services.AssetRef, bool) {
+    return services.AssetRef{Type: services.AssetEmail, ID: source.ID}, true
+}
+
+// Helper function to find an automation activity of the type by its ObjectID
+func activityRef(activityType services.AssetType) sourceRef {
+    return func(source AssetSource) (services.AssetRef, bool) {
+        return services.AssetRef{Type: activityType, ID: source.ObjectID}, true
+    }
+}
+
+// indexSources answers with the assets of one type that reference the source through the edge
+func indexSources[T any](ref sourceRef, edge services.EdgeType, from services.AssetType) indexFunc {
+    return func(graph *services.Graph, source AssetSource) (interface{}, bool) {
+        // Shared Data Extensions live in the parent business unit, whose assets aren't crawled
+        to, mustExist := ref(source)
+        if source.Shared || !graph.Complete(from) {
+            return nil, false
+        }
+        if mustExist && (!graph.Complete(to.Type) || graph.Asset(to) == nil) {
+            return nil, false
+        }
+        return indexedAssets[T](graph.Sources(to, edge, from)), true
+    }
+}
+
+// indexAutomations answers with the automations running the activity, whatever type of activity it is
+func indexAutomations(graph *services.Graph, source AssetSource) (interface{}, bool) {
+    if !graph.Complete(services.AssetAutomation) {
+        return nil, false
+    }
+    for _, activityType := range []services.AssetType{services.AssetQuery, services.AssetImport, services.AssetFilter, services.AssetScript} {
+        activity := services.AssetRef{Type: activityType, ID: source.ObjectID}
+        if graph.Asset(activity) != nil {
+            return indexedAssets[services.Automation](graph.Sources(activity, services.EdgeRuns, services.AssetAutomation)), true
+        }
+    }
+    return nil, false
+}
+
+// Helper function to return the assets as the type the live lookup returns them as
+func indexedAssets[T any](assets []services.Asset) []T {
+    results := make([]T, 0, len(assets))
+    for _, asset := range assets {
+        if data, ok := asset.Data.(T); ok {
+            results = append(results, data)
+        }
+    }
+    return results
+}
+
+// ---- Relationship Response ----
+
+// RelationshipResponse holds the relationships found for an asset, each one is sent as a top-level field under its key
+type RelationshipResponse struct {
+    Name          string
+    Relationships map[string]interface{}
+    Status        map[string]RelationshipStatus // How each selected section's lookup went, e.g. failed or truncated
+}
+
+func (r RelationshipResponse) MarshalJSON() ([]byte, error) {
+    fields := make(map[string]interface{}, len(r.Relationships)+2)
+    for key, value := range r.Relationships {
+        fields[key] = value
+    }
+    if r.Name != "" {
+        fields["name"] = r.Name
+    }
+    if len(r.Status) > 0 {
+        fields["status"] = r.Status
+    }
+    return json.Marshal(fields)
+}
+
+// cachedRelationships is what the cache keeps for an asset, only non-empty results of cacheable providers
+type cachedRelationships struct {
+    Results   map[string]interface{}
+    Truncated []string
+}
+
+// ---- Relationship Executor ----
+
+type relationshipResult struct {
+    key   string
+    value interface{}
+    err   error
+}
+
+// RelationshipSection is one section of a response, reported as soon as its lookup finishes
+type RelationshipSection struct {
+    Key    string             `json:"key"`
+    Data   interface{}        `json:"data,omitempty"`
+    Status RelationshipStatus `json:"status"`
+}
+
+// runRelationships looks up the selected relationships of the source concurrently, serving cacheable ones from the
+// cache when cacheKey is set. Sections still running when ctx is done are reported as timed out. The error is only set
+// when SFMC rejected the session's token, since every section then failed and the user has to log in again.
+func runRelationships(ctx context.Context, sourceType string, source AssetSource, selection map[string]bool, cacheKey string) (RelationshipResponse, error) {
+    return collectRelationships(ctx, sourceType, source, selection, cacheKey, nil)
+}
+
+// collectRelationships is runRelationships, calling onSection (when set) from the collecting goroutine as each
+// section finishes, in the order they finish
+func collectRelationships(ctx context.Context, sourceType string, source AssetSource, selection map[string]bool, cacheKey string, onSection func(RelationshipSection)) (RelationshipResponse, error) {
+    statuses := newSectionStatuses()
+
+    var cached cachedRelationships
+    if cacheKey != "" {
+        if entry, found := deCache.Get(cacheKey); found {
+            cached = entry.(cachedRelationships)
+            slog.DebugContext(ctx, "Cache hit", "cache_key", cacheKey)
+        } else {
+            slog.DebugContext(ctx, "Cache miss", "cache_key", cacheKey)
+        }
+    }
+
+    selected := relationshipProviders(sourceType)
+    results := make(chan relationshipResult, len(selected)) // Room for every result, so no sender blocks once collection stops
+    var wg sync.WaitGroup
+
+    for _, provider := range selected {
+        key := provider.Key()
+        if !selection[key] {
+            continue
+        }
+
+        // Serve from the cache, a section is still incomplete if it was truncated when fetched
+        if value, found := cached.Results[key]; found && provider.Cacheable() {
+            slog.DebugContext(ctx, "Using cached data for task", "task", key)
+            status := statusCached
+            if slices.Contains(cached.Truncated, key) {
+                status = statusTruncated
+            }
+            statuses.finish(key, RelationshipStatus{Status: status}, nil)
+            results <- relationshipResult{key: key, value: value}
+            continue
+        }
+
+        fetchFunc := trackSection(ctx, statuses, key, func(ctx context.Context) (interface{}, error) {
+            return provider.Fetch(ctx, source)
+        })
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            slog.DebugContext(ctx, "Starting task to fetch data", "task", key)
+
+            result, err := fetchFunc()
+            if err != nil {
+                slog.ErrorContext(ctx, "Error fetching data", "task", key, "error", err)
+            }
+            results <- relationshipResult{key: key, value: result, err: err}
+        }()
+    }
+
+    go func() {
+        wg.Wait()
+        close(results)
+    }()
+
+    response := RelationshipResponse{Name: source.Name, Relationships: make(map[string]interface{})}
+collect:
+    for {
+        select {
+        case <-ctx.Done():
+            slog.DebugContext(ctx, "Context canceled, stopping response collection")
+            break collect
+        case result, ok := <-results:
+            if !ok {
+                break collect
+            }
+            if result.err == nil {
+                response.Relationships[result.key] = result.value
+            }
+            if onSection != nil {
+                onSection(finishedSection(result, statuses))
+            }
+        }
+    }
+    response.Status = statuses.list()
+
+    // Failures are reported but never cached, the next request retries them
+    if cacheKey != "" {
+        updateCache(cacheKey, cached, selected, response)
+    }
+
+    return response, statuses.find(services.ErrAuthExpired)
+}
+
+// finishedSection gathers what was recorded for a section whose lookup just finished
+func finishedSection(result relationshipResult, statuses *sectionStatuses) RelationshipSection {
+    section := RelationshipSection{Key: result.key}
+    section.Status, _ = statuses.get(result.key)
+    if result.err == nil {
+        section.Data = result.value
+    }
+    return section
+}
+
+// updateCache adds the new non-empty results of cacheable providers that were looked up live to the asset's cache
+// entry. Whether a section is truncated always follows the fetch its cached result came from.
+func updateCache(cacheKey string, cached cachedRelationships, providers []RelationshipProvider, response RelationshipResponse) {
+    // The cached entry may be read by other requests, so it's copied before it changes
+    updated := cachedRelationships{
+        Results:   make(map[string]interface{}, len(cached.Results)),
+        Truncated: slices.Clone(cached.Truncated),
+    }
+    for key, value := range cached.Results {
+        updated.Results[key] = value
+    }
+
+    for _, provider := range providers {
+        key := provider.Key()
+        if _, cachedAlready := cached.Results[key]; cachedAlready || !provider.Cacheable() {
+            continue
+        }
+
+        // Fetched this time, so an earlier fetch's flag no longer applies
+        updated.Truncated = slices.DeleteFunc(updated.Truncated, func(section string) bool { return section == key })
+
+        // Index answers come from the session's own graph, and the cache is shared by the business unit
+        value, found := response.Relationships[key]
+        if !found || emptyResult(value) || response.Status[key].Status == statusIndexed {
+            continue
+        }
+        updated.Results[key] = value
+        if response.Status[key].Status == statusTruncated {
+            updated.Truncated = append(updated.Truncated, key)
+        }
+    }
+
+    deCache.Set(cacheKey, updated, cache.DefaultExpiration)
+}
+
+// emptyResult reports whether a provider found nothing, empty results are never cached so they're looked up again
+func emptyResult(value interface{}) bool {
+    result := reflect.ValueOf(value)
+    switch result.Kind() {
+    case reflect.Invalid:
+        return true
+    case reflect.Slice, reflect.Map, reflect.String:
+        return result.Len() == 0
+    }
+    return false
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strings"
+)
+
+// ---- Streaming Responses ----
+
+// RelationshipSummary is the final event of a streamed lookup, with the status of every section including the ones
+// that never finished
+type RelationshipSummary struct {
+    Name   string                        `json:"name,omitempty"`
+    Status map[string]RelationshipStatus `json:"status,omitempty"`
+}
+
+// wantsEventStream reports whether the client asked for Server-Sent Events instead of a single JSON response
+func wantsEventStream(r *http.Request) bool {
+    return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamRelationships looks up the selected relationships like runRelationships, but sends a "section" event as each
+// one finishes and a "done" event with the summary at the end. A rejected token ends the stream with an "error" event
+// carrying the same body as the 401 of the JSON endpoints.
+func streamRelationships(ctx context.Context, w http.ResponseWriter, sourceType string, source AssetSource, selection map[string]bool, cacheKey string) {
+    controller := http.NewResponseController(w)
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no") // Keep proxies from holding events back
+    w.WriteHeader(http.StatusOK)
+    if err := controller.Flush(); err != nil {
+        slog.WarnContext(ctx, "Response can't be streamed, events are sent when the lookup ends", "error", err)
+    }
+
+    response, err := collectRelationships(ctx, sourceType, source, selection, cacheKey, func(section RelationshipSection) {
+        writeEvent(ctx, w, controller, "section", section)
+    })
+    if err != nil {
+        writeEvent(ctx, w, controller, "error", APIErrorResponse{Error: unauthenticatedError(err)})
+        return
+    }
+
+    writeEvent(ctx, w, controller, "done", RelationshipSummary{
+        Name:   response.Name,
+        Status: response.Status,
+    })
+}
+
+// Helper function to send one Server-Sent Event and flush it to the client right away
+func writeEvent(ctx context.Context, w http.ResponseWriter, controller *http.ResponseController, event string, payload interface{}) {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        slog.ErrorContext(ctx, "Error encoding event", "event", event, "error", err)
+        return
+    }
+
+    // JSON never contains a raw newline, so the payload always fits in one data line
+    if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+        slog.DebugContext(ctx, "Client went away while streaming", "event", event, "error", err)
+        return
+    }
+    controller.Flush()
+}
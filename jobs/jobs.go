@@ -0,0 +1,361 @@
+package jobs
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "log/slog"
+    "os"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "asset_relationship_finder/services"
+)
+
+// Defaults for the job settings, see JOB_WORKERS, JOB_QUEUE_SIZE, JOB_TIMEOUT and JOB_TTL
+const (
+    defaultWorkers   = 4
+    defaultQueueSize = 100
+    defaultTimeout   = 10 * time.Minute
+    defaultTTL       = 1 * time.Hour
+)
+
+var (
+    ErrQueueFull   = errors.New("job queue is full")
+    ErrJobNotFound = errors.New("job not found")
+)
+
+// State of a job, a job ends as succeeded, timed out, failed or canceled
+type State string
+
+const (
+    StateQueued    State = "queued"
+    StateRunning   State = "running"
+    StateSucceeded State = "succeeded"
+    StateTimedOut  State = "timed_out" // JOB_TIMEOUT ended the job, its result only holds what finished in time
+    StateFailed    State = "failed"
+    StateCanceled  State = "canceled"
+)
+
+// Func is the work a job runs, it reports the tasks it runs through progress
+type Func func(ctx context.Context, progress *Progress) (interface{}, error)
+
+// Progress counts the tasks of a job, the pages it scanned are counted by the services
+type Progress struct {
+    tasksTotal atomic.Int64
+    tasksDone  atomic.Int64
+    pages      *services.Counter
+}
+
+// SetTotal sets the number of tasks the job runs
+func (p *Progress) SetTotal(total int) {
+    p.tasksTotal.Store(int64(total))
+}
+
+// TaskDone marks one more task as finished
+func (p *Progress) TaskDone() {
+    p.tasksDone.Add(1)
+}
+
+// ProgressStatus is the progress of a job when it was looked at
+type ProgressStatus struct {
+    TasksDone    int `json:"tasksDone"`
+    TasksTotal   int `json:"tasksTotal"`
+    PagesScanned int `json:"pagesScanned"`
+}
+
+func (p *Progress) status() ProgressStatus {
+    status := ProgressStatus{
+        TasksDone:  int(p.tasksDone.Load()),
+        TasksTotal: int(p.tasksTotal.Load()),
+    }
+    if p.pages != nil {
+        status.PagesScanned = p.pages.Count()
+    }
+    return status
+}
+
+// Job is a lookup running in the background, only its owner can see or cancel it
+type Job struct {
+    id       string
+    owner    string
+    ctx      context.Context
+    run      Func
+    progress Progress
+
+    mu         sync.Mutex
+    state      State
+    cancel     context.CancelFunc
+    result     interface{}
+    err        error
+    createdAt  time.Time
+    startedAt  time.Time
+    finishedAt time.Time
+}
+
+// Status is a snapshot of a job
+type Status struct {
+    ID         string         `json:"id"`
+    State      State          `json:"state"`
+    Progress   ProgressStatus `json:"progress"`
+    CreatedAt  time.Time      `json:"createdAt"`
+    StartedAt  *time.Time     `json:"startedAt,omitempty"`
+    FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+    ExpiresAt  *time.Time     `json:"expiresAt,omitempty"`
+}
+
+// Status returns where the job is at
+func (j *Job) Status() Status {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    status := Status{
+        ID:        j.id,
+        State:     j.state,
+        Progress:  j.progress.status(),
+        CreatedAt: j.createdAt,
+    }
+    if !j.startedAt.IsZero() {
+        startedAt := j.startedAt
+        status.StartedAt = &startedAt
+    }
+    if !j.finishedAt.IsZero() {
+        finishedAt := j.finishedAt
+        expiresAt := j.finishedAt.Add(jobTTL())
+        status.FinishedAt = &finishedAt
+        status.ExpiresAt = &expiresAt
+    }
+    return status
+}
+
+// Result returns the state of the job with its result or error, both are only set once it has ended
+func (j *Job) Result() (State, interface{}, error) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.state, j.result, j.err
+}
+
+// Cancel stops the job, a queued job never starts
+func (j *Job) Cancel() {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    switch j.state {
+    case StateQueued:
+        j.finish(StateCanceled, nil, context.Canceled)
+    case StateRunning:
+        // The worker records the outcome once the job's calls have stopped
+        j.cancel()
+    }
+}
+
+// finish records how the job ended, j.mu must be held
+func (j *Job) finish(state State, result interface{}, err error) {
+    j.state = state
+    j.result = result
+    j.err = err
+    j.finishedAt = time.Now()
+}
+
+// ---- Job Store ----
+
+// Jobs by ID and the queue the workers take them from
+var (
+    jobs      = make(map[string]*Job)
+    jobsMutex sync.Mutex
+    queue     chan *Job
+    startOnce sync.Once
+)
+
+// StartWorkers starts the pool of JOB_WORKERS goroutines that run the queued jobs
+func StartWorkers() {
+    startOnce.Do(func() {
+        queue = make(chan *Job, queueSize())
+        for worker := 0; worker < workers(); worker++ {
+            go func() {
+                for job := range queue {
+                    runJob(job)
+                }
+            }()
+        }
+    })
+}
+
+// Submit queues a job for the owner. ctx only carries the values of the request, e.g. its ID for the logs, the job
+// outlives it. ErrQueueFull when every worker is busy and the queue has no room left.
+func Submit(ctx context.Context, owner string, run Func) (*Job, error) {
+    StartWorkers()
+
+    job := &Job{
+        id:        newJobID(),
+        owner:     owner,
+        ctx:       context.WithoutCancel(ctx),
+        run:       run,
+        state:     StateQueued,
+        createdAt: time.Now(),
+    }
+
+    // Registered before it's queued, so canceling the owner's jobs can't miss it
+    jobsMutex.Lock()
+    jobs[job.id] = job
+    jobsMutex.Unlock()
+
+    select {
+    case queue <- job:
+    default:
+        jobsMutex.Lock()
+        delete(jobs, job.id)
+        jobsMutex.Unlock()
+        return nil, ErrQueueFull
+    }
+
+    slog.InfoContext(ctx, "Job queued", "job_id", job.id)
+    return job, nil
+}
+
+// Get returns the owner's job, ErrJobNotFound if it doesn't exist, has expired or belongs to someone else
+func Get(owner, id string) (*Job, error) {
+    jobsMutex.Lock()
+    defer jobsMutex.Unlock()
+
+    job, found := jobs[id]
+    if !found || job.owner != owner {
+        return nil, ErrJobNotFound
+    }
+    return job, nil
+}
+
+// CancelOwner cancels every job of the owner, e.g. when its session logs out
+func CancelOwner(owner string) {
+    jobsMutex.Lock()
+    var owned []*Job
+    for _, job := range jobs {
+        if job.owner == owner {
+            owned = append(owned, job)
+        }
+    }
+    jobsMutex.Unlock()
+
+    for _, job := range owned {
+        job.Cancel()
+    }
+}
+
+// runJob runs a queued job with the JOB_TIMEOUT deadline and records its outcome
+func runJob(job *Job) {
+    job.mu.Lock()
+    if job.state != StateQueued {
+        // Canceled while it waited in the queue
+        job.mu.Unlock()
+        return
+    }
+    ctx, cancel := context.WithTimeout(job.ctx, jobTimeout())
+    defer cancel()
+    ctx, job.progress.pages = services.WithPageCounter(ctx)
+    job.state = StateRunning
+    job.cancel = cancel
+    job.startedAt = time.Now()
+    job.mu.Unlock()
+
+    slog.InfoContext(ctx, "Job started", "job_id", job.id)
+    result, err := job.run(ctx, &job.progress)
+
+    job.mu.Lock()
+    defer job.mu.Unlock()
+    switch {
+    case errors.Is(ctx.Err(), context.Canceled):
+        job.finish(StateCanceled, nil, context.Canceled)
+    case errors.Is(ctx.Err(), context.DeadlineExceeded):
+        // Whatever finished in time is kept, a lookup cut off before it had a result has none
+        if err != nil {
+            result = nil
+        }
+        job.finish(StateTimedOut, result, context.DeadlineExceeded)
+    case err != nil:
+        job.finish(StateFailed, nil, err)
+    default:
+        job.finish(StateSucceeded, result, nil)
+    }
+    slog.InfoContext(ctx, "Job finished", "job_id", job.id, "state", job.state, "duration_ms", job.finishedAt.Sub(job.startedAt).Milliseconds())
+}
+
+// StartJobCleanup removes the jobs that ended more than JOB_TTL ago in the background
+func StartJobCleanup(interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for range ticker.C {
+            removed := cleanupExpiredJobs()
+            if removed > 0 {
+                slog.Info("Removed expired jobs", "count", removed)
+            }
+        }
+    }()
+}
+
+// cleanupExpiredJobs deletes every job past its expiry and returns how many were removed
+func cleanupExpiredJobs() int {
+    cutoff := time.Now().Add(-jobTTL())
+
+    jobsMutex.Lock()
+    defer jobsMutex.Unlock()
+
+    removed := 0
+    for id, job := range jobs {
+        job.mu.Lock()
+        expired := !job.finishedAt.IsZero() && job.finishedAt.Before(cutoff)
+        job.mu.Unlock()
+        if expired {
+            delete(jobs, id)
+            removed++
+        }
+    }
+    return removed
+}
+
+// Helper function to generate an unguessable job ID
+func newJobID() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        // crypto/rand never fails on supported platforms, a time based ID still keeps jobs apart
+        slog.Error("Failed to generate job ID", "error", err)
+        return strconv.FormatInt(time.Now().UnixNano(), 16)
+    }
+    return hex.EncodeToString(b)
+}
+
+// workers reads JOB_WORKERS, the jobs run at once
+func workers() int {
+    if count, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && count > 0 {
+        return count
+    }
+    return defaultWorkers
+}
+
+// queueSize reads JOB_QUEUE_SIZE, the jobs that may wait for a worker
+func queueSize() int {
+    if size, err := strconv.Atoi(os.Getenv("JOB_QUEUE_SIZE")); err == nil && size > 0 {
+        return size
+    }
+    return defaultQueueSize
+}
+
+// jobTimeout reads JOB_TIMEOUT, how long a job may run
+func jobTimeout() time.Duration {
+    if timeout, err := time.ParseDuration(os.Getenv("JOB_TIMEOUT")); err == nil && timeout > 0 {
+        return timeout
+    }
+    return defaultTimeout
+}
+
+// jobTTL reads JOB_TTL, how long a finished job and its result are kept
+func jobTTL() time.Duration {
+    if ttl, err := time.ParseDuration(os.Getenv("JOB_TTL")); err == nil && ttl > 0 {
+        return ttl
+    }
+    return defaultTTL
+}
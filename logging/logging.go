@@ -0,0 +1,146 @@
+package logging
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "io"
+    "log/slog"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// Placeholder written in place of any secret value
+const redacted = "[REDACTED]"
+
+// Attribute keys whose values are always redacted, matched case-insensitively as substrings
+var sensitiveKeyParts = []string{"token", "secret", "password", "cookie", "authorization", "verifier"}
+
+// Attribute keys that are only sensitive as an exact match, e.g. the OAuth authorization code
+var sensitiveKeys = map[string]bool{"code": true, "state": true, "nonce": true}
+
+// Catches bearer tokens that end up inside free-form messages or error strings
+var bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`)
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// Init installs the structured logger as the default for both slog and the standard log package
+// LOG_LEVEL accepts debug, info, warn or error (default info), LOG_FORMAT accepts json or text (default text)
+func Init() {
+    slog.SetDefault(slog.New(newHandler(os.Stdout)))
+}
+
+// newHandler builds the redacting, request-aware handler writing to out
+func newHandler(out io.Writer) slog.Handler {
+    options := &slog.HandlerOptions{
+        Level:       parseLevel(os.Getenv("LOG_LEVEL")),
+        ReplaceAttr: redactAttr,
+    }
+
+    var handler slog.Handler
+    if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+        handler = slog.NewJSONHandler(out, options)
+    } else {
+        handler = slog.NewTextHandler(out, options)
+    }
+
+    return &contextHandler{Handler: handler}
+}
+
+// parseLevel maps LOG_LEVEL to a slog level, defaulting to info
+func parseLevel(level string) slog.Level {
+    switch strings.ToLower(level) {
+    case "debug":
+        return slog.LevelDebug
+    case "warn", "warning":
+        return slog.LevelWarn
+    case "error":
+        return slog.LevelError
+    default:
+        return slog.LevelInfo
+    }
+}
+
+// redactAttr replaces secret values before they're written
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+    if isSensitiveKey(attr.Key) {
+        return slog.String(attr.Key, redacted)
+    }
+
+    switch attr.Value.Kind() {
+    case slog.KindString:
+        attr.Value = slog.StringValue(RedactString(attr.Value.String()))
+    case slog.KindAny:
+        // Errors and other values are rendered as text, so scrub that text too
+        if err, ok := attr.Value.Any().(error); ok {
+            attr.Value = slog.StringValue(RedactString(err.Error()))
+        }
+    }
+
+    return attr
+}
+
+// isSensitiveKey reports whether an attribute key names a secret
+func isSensitiveKey(key string) bool {
+    key = strings.ToLower(key)
+    if sensitiveKeys[key] {
+        return true
+    }
+    for _, part := range sensitiveKeyParts {
+        if strings.Contains(key, part) {
+            return true
+        }
+    }
+    return false
+}
+
+// RedactString masks bearer tokens inside free-form text such as response bodies
+func RedactString(value string) string {
+    return bearerPattern.ReplaceAllString(value, "${1}"+redacted)
+}
+
+// --- Request IDs ---
+
+// NewRequestID returns a random ID for a request that didn't bring one
+func NewRequestID() string {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        slog.Error("Failed to generate request ID", "error", err)
+        return "unknown"
+    }
+    return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context whose log records carry the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+    return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request ID stored on the context, if any
+func RequestID(ctx context.Context) string {
+    requestID, _ := ctx.Value(requestIDKey).(string)
+    return requestID
+}
+
+// contextHandler adds the request ID from the record's context to every log record
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+    if requestID := RequestID(ctx); requestID != "" {
+        record.AddAttrs(slog.String("request_id", requestID))
+    }
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -1,42 +1,100 @@
 package main
 
 import (
-    "fmt"
-    "log"
+    "context"
+    "log/slog"
     "net/http"
     "os"
+    "time"
 
+    "asset_relationship_finder/auth"
     "asset_relationship_finder/handlers"
+    "asset_relationship_finder/jobs"
+    "asset_relationship_finder/logging"
 )
 
 func main() {
+    // Structured logging with secret redaction, configured by LOG_LEVEL and LOG_FORMAT
+    logging.Init()
+
+    // Restore the sessions saved before the last restart so users don't have to log in again
+    if err := auth.InitTokenStore(); err != nil {
+        slog.Error("Token store setup failed", "error", err)
+        os.Exit(1)
+    }
+    if restored, err := auth.RestoreSessions(context.Background()); err != nil {
+        slog.Error("Could not restore sessions", "error", err)
+    } else if restored > 0 {
+        slog.Info("Restored sessions from the token store", "count", restored)
+    }
+
+    // Remove expired user sessions in the background
+    auth.StartSessionCleanup(10 * time.Minute)
+
+    // Refresh tokens in the background before they expire
+    auth.StartTokenRefresher(1 * time.Minute)
+
+    // Run lookup jobs on a bounded worker pool, and drop finished jobs once they expire
+    jobs.StartWorkers()
+    jobs.StartJobCleanup(5 * time.Minute)
+
+    // Set up the server-to-server session for headless use when enabled
+    if auth.ServerToServerEnabled() {
+        if err := auth.InitServerSession(context.Background()); err != nil {
+            slog.Error("Server-to-server auth failed", "error", err)
+            os.Exit(1)
+        }
+        slog.Info("Server-to-server auth enabled")
+    }
+
+    mux := http.NewServeMux()
+
     // Serve static files from the public folder
     fs := http.FileServer(http.Dir("public"))
-    http.Handle("/static/", http.StripPrefix("/static/", fs))
+    mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
     // Handle asset-related requests
-    http.HandleFunc("/data-extension-detail", handlers.DataExtensionDetail)
-    http.HandleFunc("/automation-activity-detail", handlers.AutomationActivityDetail)
-    http.HandleFunc("/cloud-page-detail", handlers.CloudPageDetail)
-    http.HandleFunc("/email-detail", handlers.EmailDetail)
+    mux.HandleFunc("/data-extension-detail", handlers.DataExtensionDetail)
+    mux.HandleFunc("/automation-activity-detail", handlers.AutomationActivityDetail)
+    mux.HandleFunc("/cloud-page-detail", handlers.CloudPageDetail)
+    mux.HandleFunc("/email-detail", handlers.EmailDetail)
+
+    // Handle the versioned JSON API and its OpenAPI description
+    mux.HandleFunc("/api/v1/assets/{type}/{id}/relationships", handlers.AssetRelationshipsV1)
+    mux.HandleFunc("/api/v1/assets/{type}/{id}/impact", handlers.AssetImpactV1)
+    mux.HandleFunc("/api/v1/assets/data-extension/{id}/lineage", handlers.DataExtensionLineageV1)
+    mux.HandleFunc("/api/v1/openapi.json", handlers.OpenAPIHandler)
+    mux.HandleFunc("/api/v1/", handlers.APINotFound)
+
+    // Handle lookup jobs that may outlive a request
+    mux.HandleFunc("/jobs", handlers.JobsHandler)
+    mux.HandleFunc("/jobs/{id}", handlers.JobHandler)
+    mux.HandleFunc("/jobs/{id}/result", handlers.JobResultHandler)
+
+    // Handle business unit listing and switching
+    mux.HandleFunc("/business-units", handlers.BusinessUnitsHandler)
+    mux.HandleFunc("/business-units/select", handlers.SelectBusinessUnitHandler)
 
     // Handle OAuth login and logout
-    http.HandleFunc("/auth/login", handlers.SalesforceLoginHandler)
-    http.HandleFunc("/auth/logout", handlers.SalesforceLogoutHandler)
+    mux.HandleFunc("/auth/login", handlers.SalesforceLoginHandler)
+    mux.HandleFunc("/auth/logout", handlers.SalesforceLogoutHandler)
+    mux.HandleFunc("/auth/signed-out", handlers.SignedOutHandler)
 
 
     // Handle home page 
-    http.HandleFunc("/", handlers.HomeHandler)
+    mux.HandleFunc("/", handlers.HomeHandler)
 
     // Set the port, default to 8080 if not specified
     port := os.Getenv("PORT")
     if port == "" {
         port = "8080"
-        fmt.Println("No PORT environment variable detected, defaulting to", port)
+        slog.Info("No PORT environment variable detected, using the default", "port", port)
     }
 
-    fmt.Printf("Server started at :%s\n", port)
-    if err := http.ListenAndServe(":"+port, nil); err != nil {
-        log.Fatalf("Server failed: %s", err)
+    slog.Info("Server started", "port", port)
+    // Tag and log every request, and require a valid session on every route except static files, OAuth routes, the OpenAPI document and the home page
+    if err := http.ListenAndServe(":"+port, handlers.RequestLogger(handlers.RequireSession(mux))); err != nil {
+        slog.Error("Server failed", "error", err)
+        os.Exit(1)
     }
 }
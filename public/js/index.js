@@ -13,6 +13,7 @@ document.addEventListener('DOMContentLoaded', function () {
             const resultSection = document.getElementById('resultsContent');
             const goTopBtn = document.getElementById('goTopBtn');
             const selectedAsset = document.getElementById('selectedAsset');
+            const businessUnitSelect = document.getElementById('businessUnitSelect');
 
             const formMap = {
                 dataextensions: dataExtensionForm.classList,
@@ -41,6 +42,79 @@ document.addEventListener('DOMContentLoaded', function () {
                 window.scrollTo({ top: 0, behavior: 'smooth' });
             });
 
+            // Redirect to the login page when the server reports a missing or expired session
+            async function redirectIfUnauthenticated(response) {
+                if (response.status !== 401) {
+                    return false;
+                }
+
+                let loginUrl = '/auth/login';
+                try {
+                    const body = await response.json();
+                    loginUrl = (body.error && body.error.loginUrl) || loginUrl;
+                } catch (error) {
+                    console.log('Unexpected 401 response body:', error);
+                }
+
+                window.location.href = loginUrl;
+                return true;
+            }
+
+            // Load the business units the user can access and mark the active one
+            async function loadBusinessUnits() {
+                try {
+                    const response = await fetch('/business-units');
+                    if (await redirectIfUnauthenticated(response) || !response.ok) {
+                        return;
+                    }
+
+                    const result = await response.json();
+                    businessUnitSelect.innerHTML = '';
+                    (result.businessUnits || []).forEach(businessUnit => {
+                        const option = document.createElement('option');
+                        option.value = businessUnit.mid;
+                        option.textContent = `${businessUnit.name} (${businessUnit.mid})`;
+                        option.selected = businessUnit.mid === result.currentMID;
+                        businessUnitSelect.appendChild(option);
+                    });
+                    businessUnitSelect.disabled = false;
+                } catch (error) {
+                    console.log('Failed to load business units:', error);
+                }
+            }
+
+            loadBusinessUnits();
+
+            // Switch the active business unit when another one is selected
+            businessUnitSelect.addEventListener('change', async function () {
+                businessUnitSelect.disabled = true;
+                errorMessage.classList.add('d-none');
+
+                try {
+                    const response = await fetch('/business-units/select', {
+                        method: 'POST',
+                        headers: { 'Content-Type': 'application/json' },
+                        body: JSON.stringify({ mid: this.value })
+                    });
+
+                    if (await redirectIfUnauthenticated(response)) {
+                        return;
+                    }
+
+                    if (!response.ok) {
+                        handleError(await response.text());
+                        return;
+                    }
+
+                    resetResults();
+                    resultsPlaceholder.innerHTML = 'Information will be shown here.';
+                } catch (error) {
+                    handleError('An error occurred while switching business units. Please try again.');
+                } finally {
+                    businessUnitSelect.disabled = false;
+                }
+            });
+
             // Show/hide related asset form based on asset type selection
             assetTypeSelect.addEventListener('change', function () {
                 const selectedText = this.value;  // Capture the original value for display
@@ -144,22 +218,37 @@ document.addEventListener('DOMContentLoaded', function () {
                     }
                 }
 
+                // Relationship lookups are streamed, so each section shows up as soon as it's found
+                const streamed = type === 'dataExtension' || type === 'cloudPage' || type === 'email';
+
                 try {
                         const response = await fetch(`/${type === 'dataExtension' ? 'data-extension-detail' : 
                                                          type === 'cloudPage' ? 'cloud-page-detail' : 
                                                          type === 'email' ? 'email-detail' : 'automation-activity-detail'}`, {
 
                         method: 'POST',
-                        headers: { 'Content-Type': 'application/json' },
+                        headers: {
+                            'Content-Type': 'application/json',
+                            'Accept': streamed ? 'text/event-stream' : 'application/json'
+                        },
                         body: JSON.stringify(requestData)
                     });
 
+                    if (await redirectIfUnauthenticated(response)) {
+                        return;
+                    }
+
                     if (!response.ok) {
                         const errorText = await response.text();
                         handleError(errorText);
                         return;
                     }
 
+                    if ((response.headers.get('Content-Type') || '').startsWith('text/event-stream')) {
+                        await readRelationshipStream(response, requestData, selectedCheckboxes, type);
+                        return;
+                    }
+
                     const result = await response.json();
                     processResults(result, requestData, selectedCheckboxes, type); 
 
@@ -173,6 +262,61 @@ document.addEventListener('DOMContentLoaded', function () {
                 }
             }
 
+            // Read the events of a streamed lookup, rendering the results again as each section arrives
+            async function readRelationshipStream(response, requestData, selectedCheckboxes, type) {
+                const result = { pending: true, status: {} };
+                processResults(result, requestData, selectedCheckboxes, type);
+
+                const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+                let buffer = '';
+                while (true) {
+                    const { value, done } = await reader.read();
+                    if (done) {
+                        break;
+                    }
+                    buffer += value;
+
+                    // Events are separated by a blank line
+                    let boundary;
+                    while ((boundary = buffer.indexOf('\n\n')) !== -1) {
+                        const event = parseStreamEvent(buffer.slice(0, boundary));
+                        buffer = buffer.slice(boundary + 2);
+
+                        if (event.name === 'section') {
+                            const section = event.data;
+                            result[section.key] = section.data;
+                            result.status[section.key] = section.status;
+                        } else if (event.name === 'done') {
+                            result.pending = false;
+                            result.name = event.data.name;
+                            result.status = event.data.status || {};
+                        } else if (event.name === 'error') {
+                            window.location.href = (event.data.error && event.data.error.loginUrl) || '/auth/login';
+                            return;
+                        }
+                        processResults(result, requestData, selectedCheckboxes, type);
+                    }
+                }
+
+                // Without the final event some sections never arrived, so they can't be reported as empty
+                if (result.pending) {
+                    handleError('The connection was lost before every lookup finished. Please try again.');
+                }
+            }
+
+            // Split one Server-Sent Event into its name and JSON data
+            function parseStreamEvent(rawEvent) {
+                const event = { name: 'message', data: null };
+                rawEvent.split('\n').forEach(line => {
+                    if (line.startsWith('event: ')) {
+                        event.name = line.slice('event: '.length);
+                    } else if (line.startsWith('data: ')) {
+                        event.data = JSON.parse(line.slice('data: '.length));
+                    }
+                });
+                return event;
+            }
+
             // Function to build request data based on form inputs
             function buildRequestData(type, inputKeyValue) {
                 let requestData = {};
@@ -223,6 +367,64 @@ document.addEventListener('DOMContentLoaded', function () {
                 return requestData;
             }
 
+            // Warn when the server cut a section off at its paging limit
+            function truncatedNotice(result, optionname) {
+                const status = result.status && result.status[optionname];
+                const truncated = (status && status.status === 'truncated') ||
+                    (result.truncated && result.truncated.includes(optionname));
+                if (!truncated) {
+                    return '';
+                }
+                return `<p class="text-warning small">Only part of the results could be retrieved, the list may be incomplete.</p>`;
+            }
+
+            // Explain a failed lookup instead of reporting it as having no results
+            function sectionErrorNotice(result, optionname) {
+                const status = result.status && result.status[optionname];
+                if (!status || status.status !== 'error') {
+                    return '';
+                }
+                const reason = status.code === 'permission_denied'
+                    ? 'Permission denied, check the installed package scopes.'
+                    : status.code === 'throttled'
+                        ? 'Salesforce Marketing Cloud is rate limiting requests, please try again shortly.'
+                        : 'The lookup failed.';
+                return `<p class="text-danger small">${reason} ${status.error}</p>`;
+            }
+
+            // A section that timed out has no results to show either
+            function sectionTimeoutNotice(result, optionname) {
+                const status = result.status && result.status[optionname];
+                if (!status || status.status !== 'timeout') {
+                    return '';
+                }
+                return `<p class="text-danger small">The lookup timed out, please try again.</p>`;
+            }
+
+            // A section of a streamed lookup that hasn't finished yet
+            function sectionPendingNotice(result, optionname) {
+                if (!result.pending || (result.status && result.status[optionname])) {
+                    return '';
+                }
+                return `<p class="text-muted small">Looking up...</p>`;
+            }
+
+            // Show where a section's results came from and what the lookup cost
+            function sectionStatusDetails(result, optionname) {
+                const status = result.status && result.status[optionname];
+                if (!status || status.status === 'timeout' || status.status === 'error') {
+                    return '';
+                }
+                if (status.status === 'cached') {
+                    return `<p class="text-muted small">From cache</p>`;
+                }
+                if (status.status === 'indexed') {
+                    return `<p class="text-muted small">From the account index</p>`;
+                }
+                const seconds = (status.durationMs / 1000).toFixed(1);
+                return `<p class="text-muted small">${seconds}s, ${status.calls} SFMC ${status.calls === 1 ? 'call' : 'calls'}</p>`;
+            }
+
              // Function to process and display results based on selected checkboxes
             function processResults(result, requestData, selectedCheckboxes, type) {
                 resultsPlaceholder.classList.add('hidden');
@@ -283,9 +485,22 @@ document.addEventListener('DOMContentLoaded', function () {
                         // Always show the title
                         resultHtml += `<div class="result-container">`;
                         resultHtml += `<h6 class="fw-bold">${title}</h6>`;
+                        resultHtml += truncatedNotice(result, optionname);
 
+                        resultHtml += sectionStatusDetails(result, optionname);
+
+                        // A failed or unfinished lookup has no results to show
+                        if (sectionPendingNotice(result, optionname)) {
+                            resultHtml += sectionPendingNotice(result, optionname);
+                        }
+                        else if (sectionErrorNotice(result, optionname)) {
+                            resultHtml += sectionErrorNotice(result, optionname);
+                        }
+                        else if (sectionTimeoutNotice(result, optionname)) {
+                            resultHtml += sectionTimeoutNotice(result, optionname);
+                        }
                         // For path (which is a string)
-                        if (optionname === 'dePath') {
+                        else if (optionname === 'dePath') {
                             if (data) {
                                 resultHtml += `<p>${data}</p>`;
                             } else {
@@ -328,6 +543,7 @@ document.addEventListener('DOMContentLoaded', function () {
                     const automations = result.automations || [];
                     resultHtml += `<div class="result-container">`;
                     resultHtml += `<h6 class="fw-bold">Automations</h6>`;
+                    resultHtml += truncatedNotice(result, 'automations');
 
                     if (automations.length > 0) {
                         resultHtml += `<ul class="list-group">`;
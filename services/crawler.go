@@ -0,0 +1,603 @@
+package services
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "asset_relationship_finder/auth"
+)
+
+// --- Account Crawler ---
+
+// Defaults for the index settings, see INDEX_ENABLED, INDEX_MAX_AGE and INDEX_CRAWL_TIMEOUT
+const (
+    defaultIndexMaxAge  = 30 * time.Minute
+    defaultCrawlTimeout = 30 * time.Minute
+    crawlRetryBackoff   = 5 * time.Minute // Wait after a failed crawl before another one may start
+    crawlPageSize       = 50
+)
+
+// Assets as the crawler reads them, with the references the live lookups search for
+type crawledQuery struct {
+    QueryDefinition
+    QueryText  string `xml:"QueryText"`
+    TargetName string `xml:"DataExtensionTarget>Name"`
+}
+
+type crawledImport struct {
+    ImportDefinition
+    DestinationObjectID string `xml:"DestinationObject>ObjectID"`
+}
+
+type crawledFilter struct {
+    FilterActivity
+    DestinationObjectID string `xml:"DestinationObjectID"`
+    DestinationTypeID   string `xml:"DestinationTypeID"`
+}
+
+type crawledTriggeredSend struct {
+    TriggeredSendDefinition
+    ObjectID string `xml:"ObjectID"`
+    EmailID  string `xml:"Email>ID"`
+}
+
+type crawledActivity struct {
+    ProgramObjectID    string `xml:"Program>ObjectID"`
+    DefinitionObjectID string `xml:"Definition>ObjectID"`
+}
+
+// Content Builder emails and CloudPages, with every content field combined
+type crawledContent struct {
+    ID      string
+    Name    string
+    Content string
+}
+
+type crawledJourney struct {
+    Journey
+    Defaults struct {
+        Email []string `json:"email"`
+    } `json:"defaults"`
+    Activities []struct {
+        Type                   string `json:"type"`
+        ConfigurationArguments struct {
+            TriggeredSend struct {
+                EmailID interface{} `json:"emailId"`
+            } `json:"triggeredSend"`
+        } `json:"configurationArguments"`
+    } `json:"activities"`
+    EntryDataExtension string `json:"-"` // Data Extension read by the entry event
+}
+
+// crawlResults holds everything read from the account before it's linked into a graph
+type crawlResults struct {
+    mu         sync.Mutex
+    incomplete map[AssetType]error
+
+    dataExtensions  []DataExtension
+    queries         []crawledQuery
+    imports         []crawledImport
+    filters         []crawledFilter
+    scripts         []scriptItem
+    emails          []crawledContent
+    cloudPages      []crawledContent
+    journeys        []crawledJourney
+    triggeredSends  []crawledTriggeredSend
+    sendDefinitions []emailSendDefinitionResult
+    automations     []Automation
+    activities      []crawledActivity
+}
+
+// fail records that an asset type couldn't be read in full, what was read is still linked
+func (c *crawlResults) fail(assetType AssetType, err error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.incomplete[assetType] = err
+}
+
+// CrawlAccount reads every asset of the session's business unit once and links the references between them into a
+// graph. Asset types that fail or are truncated are listed in Graph.Incomplete instead of failing the crawl, only a
+// rejected token or the end of ctx does.
+func CrawlAccount(ctx context.Context, sessionID string) (*Graph, error) {
+    mid := auth.GetMID(sessionID)
+    started := time.Now()
+    results := &crawlResults{incomplete: make(map[AssetType]error)}
+
+    var wg sync.WaitGroup
+    crawl := func(assetType AssetType, read func() error) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := read(); err != nil {
+                slog.WarnContext(ctx, "Crawl incomplete", "mid", mid, "asset_type", assetType, "error", err)
+                results.fail(assetType, err)
+            }
+        }()
+    }
+
+    crawl(AssetDataExtension, func() (err error) {
+        results.dataExtensions, err = GetDataExtensions(ctx, sessionID, nil, false)
+        return err
+    })
+    crawl(AssetQuery, func() (err error) {
+        results.queries, err = retrieveAll[crawledQuery](ctx, sessionID, RetrieveRequest{
+            ObjectType: "QueryDefinition",
+            Properties: []string{"Name", "ObjectID", "QueryText", "DataExtensionTarget.Name"},
+        })
+        return err
+    })
+    crawl(AssetImport, func() (err error) {
+        results.imports, err = retrieveAll[crawledImport](ctx, sessionID, RetrieveRequest{
+            ObjectType: "ImportDefinition",
+            Properties: []string{"Name", "ObjectID", "DestinationObject.ObjectID"},
+        })
+        return err
+    })
+    crawl(AssetFilter, func() (err error) {
+        results.filters, err = retrieveAll[crawledFilter](ctx, sessionID, RetrieveRequest{
+            ObjectType: "FilterActivity",
+            Properties: []string{"Name", "ObjectID", "DestinationObjectID", "DestinationTypeID"},
+        })
+        return err
+    })
+    crawl(AssetTriggeredSend, func() (err error) {
+        results.triggeredSends, err = retrieveAll[crawledTriggeredSend](ctx, sessionID, RetrieveRequest{
+            ObjectType: "TriggeredSendDefinition",
+            Properties: []string{"Name", "ObjectID", "Email.ID"},
+            Filter:     NotEquals("TriggeredSendStatus", "Deleted"),
+        })
+        return err
+    })
+    crawl(AssetSendDefinition, func() (err error) {
+        results.sendDefinitions, err = retrieveAll[emailSendDefinitionResult](ctx, sessionID, RetrieveRequest{
+            ObjectType: "EmailSendDefinition",
+            Properties: []string{"Name", "ObjectID", "SendDefinitionList", "Email.ID"},
+        })
+        return err
+    })
+    crawl(AssetAutomation, func() error {
+        automations, err := retrieveAll[Automation](ctx, sessionID, RetrieveRequest{
+            ObjectType: "Program",
+            Properties: []string{"Name", "ObjectID"},
+        })
+        results.automations = automations
+        if err != nil {
+            return err
+        }
+        results.activities, err = retrieveAll[crawledActivity](ctx, sessionID, RetrieveRequest{
+            ObjectType: "Activity",
+            Properties: []string{"Program.ObjectID", "Definition.ObjectID"},
+        })
+        return err
+    })
+    crawl(AssetScript, func() (err error) {
+        results.scripts, err = Paginate(ctx, crawlPageSize, func(ctx context.Context, page int) ([]scriptItem, int, error) {
+            return fetchScriptPage(ctx, sessionID, page, crawlPageSize)
+        })
+        return err
+    })
+    crawl(AssetEmail, func() (err error) {
+        results.emails, err = crawlContent(ctx, sessionID, emailAssetQuery(), legacyEmailID)
+        return err
+    })
+    crawl(AssetCloudPage, func() (err error) {
+        results.cloudPages, err = crawlContent(ctx, sessionID, cloudPageAssetQuery(), assetID)
+        return err
+    })
+    crawl(AssetJourney, func() (err error) {
+        results.journeys, err = crawlJourneys(ctx, sessionID)
+        return err
+    })
+
+    wg.Wait()
+
+    // A crawl cut short or made with a rejected token says nothing about the account
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    for _, err := range results.incomplete {
+        if errors.Is(err, ErrAuthExpired) {
+            return nil, err
+        }
+    }
+
+    // The business unit may have been switched while the crawl ran
+    if auth.GetMID(sessionID) != mid {
+        return nil, fmt.Errorf("business unit changed from %s while crawling", mid)
+    }
+
+    graph := linkGraph(mid, results)
+    graph.BuiltAt = started
+    return graph, nil
+}
+
+// linkGraph adds every crawled asset to a graph along with the references between them
+func linkGraph(mid string, results *crawlResults) *Graph {
+    graph := newGraph(mid)
+    for assetType, err := range results.incomplete {
+        graph.Incomplete[assetType] = err.Error()
+    }
+
+    // Data Extensions are found the way the live lookups search for them: by name in query text, ignoring case as SOAP's
+    // like does, by quoted name in email content, and by name or CustomerKey in scripts and CloudPages
+    names := make(nameIndex)
+    queryReads := &referenceIndex{ignoreCase: true}
+    emailReads := &referenceIndex{}
+    contentReads := &referenceIndex{}
+    for _, dataExtension := range results.dataExtensions {
+        ref := AssetRef{Type: AssetDataExtension, ID: dataExtension.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: dataExtension.Name, Key: dataExtension.CustomerKey, Data: dataExtension})
+        names.add(dataExtension.Name, ref)
+        queryReads.add(ref, dataExtension.Name)
+        if dataExtension.Name != "" {
+            emailReads.add(ref, strconv.Quote(dataExtension.Name))
+        }
+        contentReads.add(ref, dataExtension.Name, dataExtension.CustomerKey)
+    }
+
+    for _, query := range results.queries {
+        ref := AssetRef{Type: AssetQuery, ID: query.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: query.Name, Data: query.QueryDefinition})
+        for _, target := range names[strings.ToLower(query.TargetName)] {
+            graph.addEdge(ref, target, EdgeTargets)
+        }
+        for _, read := range queryReads.find(query.QueryText) {
+            graph.addEdge(ref, read, EdgeReads)
+        }
+    }
+
+    for _, importDefinition := range results.imports {
+        if generatedImportName(importDefinition.Name) {
+            continue
+        }
+        ref := AssetRef{Type: AssetImport, ID: importDefinition.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: importDefinition.Name, Data: importDefinition.ImportDefinition})
+        if importDefinition.DestinationObjectID != "" {
+            graph.addEdge(ref, AssetRef{Type: AssetDataExtension, ID: importDefinition.DestinationObjectID}, EdgeTargets)
+        }
+    }
+
+    for _, filter := range results.filters {
+        if generatedFilterName(filter.Name) {
+            continue
+        }
+        ref := AssetRef{Type: AssetFilter, ID: filter.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: filter.Name, Data: filter.FilterActivity})
+        // Destination type 2 is a Data Extension, the others are lists
+        if filter.DestinationTypeID == "2" && filter.DestinationObjectID != "" {
+            graph.addEdge(ref, AssetRef{Type: AssetDataExtension, ID: filter.DestinationObjectID}, EdgeTargets)
+        }
+    }
+
+    for _, script := range results.scripts {
+        ref := AssetRef{Type: AssetScript, ID: script.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: script.Name, Data: script.Script})
+        for _, read := range contentReads.find(script.Content) {
+            graph.addEdge(ref, read, EdgeReads)
+        }
+    }
+
+    for _, email := range results.emails {
+        ref := AssetRef{Type: AssetEmail, ID: email.ID}
+        graph.addAsset(Asset{AssetRef: ref, Name: email.Name, Data: Email{Name: email.Name, ID: json.Number(email.ID)}})
+        for _, read := range emailReads.find(email.Content) {
+            graph.addEdge(ref, read, EdgeReads)
+        }
+        for _, pageID := range cloudPageLinks(email.Content) {
+            graph.addEdge(ref, AssetRef{Type: AssetCloudPage, ID: pageID}, EdgeLinks)
+        }
+    }
+
+    for _, cloudPage := range results.cloudPages {
+        ref := AssetRef{Type: AssetCloudPage, ID: cloudPage.ID}
+        graph.addAsset(Asset{AssetRef: ref, Name: cloudPage.Name, Data: CloudPage{Name: cloudPage.Name}})
+        for _, read := range contentReads.find(cloudPage.Content) {
+            graph.addEdge(ref, read, EdgeReads)
+        }
+        for _, pageID := range cloudPageLinks(cloudPage.Content) {
+            graph.addEdge(ref, AssetRef{Type: AssetCloudPage, ID: pageID}, EdgeLinks)
+        }
+    }
+
+    for _, journey := range results.journeys {
+        ref := AssetRef{Type: AssetJourney, ID: journey.ID}
+        graph.addAsset(Asset{AssetRef: ref, Name: journey.Name, Data: journey.Journey})
+        for _, entry := range names[strings.ToLower(journey.EntryDataExtension)] {
+            graph.addEdge(ref, entry, EdgeEntrySource)
+        }
+        for _, activity := range journey.Activities {
+            if emailID, ok := activity.ConfigurationArguments.TriggeredSend.EmailID.(float64); ok && activity.Type == "EMAILV2" {
+                graph.addEdge(ref, AssetRef{Type: AssetEmail, ID: fmt.Sprintf("%.0f", emailID)}, EdgeSends)
+            }
+        }
+    }
+
+    for _, triggeredSend := range results.triggeredSends {
+        if generatedTriggeredSendName(triggeredSend.Name) {
+            continue
+        }
+        ref := AssetRef{Type: AssetTriggeredSend, ID: triggeredSend.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: triggeredSend.Name, Data: triggeredSend.TriggeredSendDefinition})
+        if triggeredSend.EmailID != "" {
+            graph.addEdge(ref, AssetRef{Type: AssetEmail, ID: triggeredSend.EmailID}, EdgeSends)
+        }
+    }
+
+    for _, sendDefinition := range results.sendDefinitions {
+        customObjectID, emailID := sendDefinition.SendDefinitionList.CustomObjectID, sendDefinition.Email.ID
+        if (customObjectID == "" && emailID == "") || generatedSendDefinitionName(sendDefinition.Name) {
+            continue
+        }
+        ref := AssetRef{Type: AssetSendDefinition, ID: sendDefinition.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: sendDefinition.Name, Data: EmailSendDefinition{
+            Name:           sendDefinition.Name,
+            ObjectID:       sendDefinition.ObjectID,
+            CustomObjectID: customObjectID,
+            EmailID:        emailID,
+        }})
+        if customObjectID != "" {
+            graph.addEdge(ref, AssetRef{Type: AssetDataExtension, ID: customObjectID}, EdgeTargets)
+        }
+        if emailID != "" {
+            graph.addEdge(ref, AssetRef{Type: AssetEmail, ID: emailID}, EdgeSends)
+        }
+    }
+
+    // An automation step names its activity only by ObjectID, whatever type of activity it is
+    for _, automation := range results.automations {
+        ref := AssetRef{Type: AssetAutomation, ID: automation.ObjectID}
+        graph.addAsset(Asset{AssetRef: ref, Name: automation.Name, Data: automation})
+    }
+    for _, activity := range results.activities {
+        automation := AssetRef{Type: AssetAutomation, ID: activity.ProgramObjectID}
+        for _, activityType := range []AssetType{AssetQuery, AssetImport, AssetFilter, AssetScript} {
+            definition := AssetRef{Type: activityType, ID: activity.DefinitionObjectID}
+            if graph.Asset(definition) != nil {
+                graph.addEdge(automation, definition, EdgeRuns)
+            }
+        }
+    }
+
+    return graph
+}
+
+// crawlContent reads every Content Builder asset matching the query with its content, id reads the ID to index it by
+func crawlContent(ctx context.Context, sessionID string, query map[string]interface{}, id func(map[string]interface{}) string) ([]crawledContent, error) {
+    items, err := Paginate(ctx, crawlPageSize, func(ctx context.Context, page int) ([]map[string]interface{}, int, error) {
+        jsonBody, err := json.Marshal(assetQueryPage(query, page, crawlPageSize))
+        if err != nil {
+            return nil, 0, err
+        }
+        return getRESTPage[map[string]interface{}](ctx, sessionID, "POST", "/asset/v1/content/assets/query", jsonBody)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    contents := make([]crawledContent, 0, len(items))
+    for _, item := range items {
+        itemID := id(item)
+        if itemID == "" {
+            continue
+        }
+        name, _ := item["name"].(string)
+        contents = append(contents, crawledContent{ID: itemID, Name: name, Content: combineAllContent(item)})
+    }
+    return contents, nil
+}
+
+// Helper function to read the Content Builder ID of an asset
+func assetID(item map[string]interface{}) string {
+    if id, ok := item["id"].(float64); ok {
+        return fmt.Sprintf("%.0f", id)
+    }
+    return ""
+}
+
+// emailAssetQuery selects every template based and HTML email, with the content the live lookups search
+func emailAssetQuery() map[string]interface{} {
+    return map[string]interface{}{
+        "query": map[string]interface{}{
+            "leftOperand": map[string]interface{}{
+                "property":       "assetType.name",
+                "simpleOperator": "equal",
+                "value":          "templatebasedemail",
+            },
+            "logicalOperator": "OR",
+            "rightOperand": map[string]interface{}{
+                "property":       "assetType.name",
+                "simpleOperator": "equal",
+                "value":          "htmlemail",
+            },
+        },
+        "sort": []map[string]interface{}{
+            {"property": "id", "direction": "ASC"},
+        },
+        "fields": []string{"name", "id", "views", "content", "data"},
+    }
+}
+
+// cloudPageAssetQuery selects every CloudPage with content
+func cloudPageAssetQuery() map[string]interface{} {
+    return map[string]interface{}{
+        "query": map[string]interface{}{
+            "leftOperand": map[string]interface{}{
+                "property":       "content",
+                "simpleOperator": "isNotNull",
+            },
+            "logicalOperator": "AND",
+            "rightOperand": map[string]interface{}{
+                "property":       "assetType.name",
+                "simpleOperator": "equal",
+                "value":          "webpage",
+            },
+        },
+        "sort": []map[string]interface{}{
+            {"property": "id", "direction": "ASC"},
+        },
+        "fields": []string{"name", "id", "views"},
+    }
+}
+
+// crawlJourneys reads every journey with its activities, then the Data Extension of each one's entry event
+func crawlJourneys(ctx context.Context, sessionID string) ([]crawledJourney, error) {
+    journeys, err := Paginate(ctx, crawlPageSize, func(ctx context.Context, page int) ([]crawledJourney, int, error) {
+        path := fmt.Sprintf("/interaction/v1/interactions?$page=%d&$pageSize=%d&extras=activities", page, crawlPageSize)
+        return getRESTPage[crawledJourney](ctx, sessionID, "GET", path, nil)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // A fixed number of workers look up the event definitions, like they do for the live lookup
+    forEachIndex(ctx, len(journeys), func(i int) {
+        journey := &journeys[i]
+
+        eventDefinitionKey := ""
+        if len(journey.Defaults.Email) > 0 {
+            eventDefinitionKey = extractEventDefinitionKey(journey.Defaults.Email[0])
+        }
+        eventDef, err := fetchEventDefinition(ctx, sessionID, eventDefinitionKey, journey.Name)
+        if err != nil {
+            slog.DebugContext(ctx, "No event definition for journey", "journey", journey.Name, "error", err)
+            return
+        }
+        journey.EntryDataExtension = eventDef.DataExtensionName
+    })
+
+    return journeys, ctx.Err()
+}
+
+// --- Graph Index ---
+
+// indexEntry is the latest graph of a session's business unit and whether a crawl for it is running
+type indexEntry struct {
+    sessionID string
+    graph     *Graph
+    crawling  bool
+    failedAt  time.Time // When the last crawl failed, zero once one succeeds
+}
+
+// Graphs by session and MID. Each one is crawled with the session's own token, so it only holds what that user may
+// see and never answers another user.
+var (
+    indexes      = make(map[string]*indexEntry)
+    indexesMutex sync.Mutex
+)
+
+// indexKey scopes a graph to the session that crawled it and the business unit it was crawled in
+func indexKey(sessionID, mid string) string {
+    return sessionID + ":" + mid
+}
+
+// FreshIndex returns the graph of the session's business unit when it's younger than INDEX_MAX_AGE. When there's
+// none or it's stale, a crawl with the session starts in the background and nil is returned, so the caller looks the
+// relationship up live this time. After a failed crawl no other one starts for a while, so every lookup doesn't
+// spend the API budget on a crawl that's likely to fail again.
+func FreshIndex(sessionID string) *Graph {
+    if !IndexEnabled() {
+        return nil
+    }
+    mid := auth.GetMID(sessionID)
+    if mid == "" {
+        return nil
+    }
+
+    indexesMutex.Lock()
+    defer indexesMutex.Unlock()
+
+    key := indexKey(sessionID, mid)
+    entry, found := indexes[key]
+    if !found {
+        pruneIndexes()
+        entry = &indexEntry{sessionID: sessionID}
+        indexes[key] = entry
+    }
+    if entry.graph != nil && time.Since(entry.graph.BuiltAt) < indexMaxAge() {
+        return entry.graph
+    }
+    if !entry.crawling && time.Since(entry.failedAt) >= crawlRetryBackoff {
+        entry.crawling = true
+        go refreshIndex(sessionID, mid)
+    }
+    return nil
+}
+
+// ForgetIndexes drops every graph the session crawled, e.g. when it logs out
+func ForgetIndexes(sessionID string) {
+    indexesMutex.Lock()
+    defer indexesMutex.Unlock()
+
+    for key, entry := range indexes {
+        if entry.sessionID == sessionID {
+            delete(indexes, key)
+        }
+    }
+}
+
+// pruneIndexes drops the graphs of sessions that have expired, the caller must hold indexesMutex
+func pruneIndexes() {
+    for key, entry := range indexes {
+        if !entry.crawling && !auth.SessionLive(entry.sessionID) {
+            delete(indexes, key)
+        }
+    }
+}
+
+// refreshIndex crawls the business unit and replaces the session's graph of it, a failed crawl keeps the previous one
+func refreshIndex(sessionID, mid string) {
+    // Not tied to the request that started it, which may well end before the crawl does
+    ctx, cancel := context.WithTimeout(context.Background(), crawlTimeout())
+    defer cancel()
+
+    slog.Info("Crawling business unit for the index", "mid", mid)
+    graph, err := CrawlAccount(ctx, sessionID)
+
+    indexesMutex.Lock()
+    defer indexesMutex.Unlock()
+    entry, found := indexes[indexKey(sessionID, mid)]
+    if !found {
+        // The session logged out while the crawl ran
+        return
+    }
+    entry.crawling = false
+    if err != nil {
+        entry.failedAt = time.Now()
+        slog.Warn("Index crawl failed", "mid", mid, "retry_in", crawlRetryBackoff.String(), "error", err)
+        return
+    }
+
+    entry.graph = graph
+    entry.failedAt = time.Time{}
+    assets, edges := graph.Size()
+    slog.Info("Index built", "mid", mid, "assets", assets, "edges", edges, "incomplete", len(graph.Incomplete), "duration_ms", time.Since(graph.BuiltAt).Milliseconds())
+}
+
+// IndexEnabled reads INDEX_ENABLED, the index is used unless it's set to false
+func IndexEnabled() bool {
+    return !strings.EqualFold(os.Getenv("INDEX_ENABLED"), "false")
+}
+
+// indexMaxAge reads INDEX_MAX_AGE, how long a graph answers lookups before it's crawled again
+func indexMaxAge() time.Duration {
+    if maxAge, err := time.ParseDuration(os.Getenv("INDEX_MAX_AGE")); err == nil && maxAge > 0 {
+        return maxAge
+    }
+    return defaultIndexMaxAge
+}
+
+// crawlTimeout reads INDEX_CRAWL_TIMEOUT, how long a crawl may run
+func crawlTimeout() time.Duration {
+    if timeout, err := time.ParseDuration(os.Getenv("INDEX_CRAWL_TIMEOUT")); err == nil && timeout > 0 {
+        return timeout
+    }
+    return defaultCrawlTimeout
+}
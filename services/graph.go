@@ -0,0 +1,324 @@
+package services
+
+import (
+    "regexp"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+)
+
+// --- Dependency Graph ---
+
+// AssetType is the kind of an asset in the dependency graph
+type AssetType string
+
+const (
+    AssetDataExtension  AssetType = "dataExtension"
+    AssetQuery          AssetType = "query"
+    AssetImport         AssetType = "import"
+    AssetFilter         AssetType = "filter"
+    AssetScript         AssetType = "script"
+    AssetEmail          AssetType = "email"
+    AssetCloudPage      AssetType = "cloudPage"
+    AssetJourney        AssetType = "journey"
+    AssetTriggeredSend  AssetType = "triggeredSend"
+    AssetSendDefinition AssetType = "sendDefinition"
+    AssetAutomation     AssetType = "automation"
+)
+
+// EdgeType is how one asset depends on another, edges point from the asset holding the reference
+type EdgeType string
+
+const (
+    EdgeTargets     EdgeType = "targets"     // A query, import, filter or send definition writes or sends to a Data Extension
+    EdgeReads       EdgeType = "reads"       // Query text or script, email or CloudPage content names a Data Extension
+    EdgeEntrySource EdgeType = "entrySource" // A journey's entry event reads a Data Extension
+    EdgeSends       EdgeType = "sends"       // A journey, triggered send or send definition sends an email
+    EdgeLinks       EdgeType = "links"       // An email or CloudPage links to a CloudPage
+    EdgeRuns        EdgeType = "runs"        // An automation runs a query, import, filter or script
+)
+
+// AssetRef identifies an asset: Data Extensions, activities and automations by ObjectID, emails by legacy ID,
+// CloudPages by asset ID and journeys by ID
+type AssetRef struct {
+    Type AssetType
+    ID   string
+}
+
+// Asset is a node of the graph, Data holds the asset as the live lookups return it, e.g. a QueryDefinition
+type Asset struct {
+    AssetRef
+    Name string
+    Key  string // CustomerKey of a Data Extension
+    Data interface{}
+}
+
+// Edge is a typed reference from one asset to another
+type Edge struct {
+    From AssetRef
+    To   AssetRef
+    Type EdgeType
+}
+
+// Graph holds every asset of a business unit and the references between them, as crawled at BuiltAt
+type Graph struct {
+    MID        string
+    BuiltAt    time.Time
+    Incomplete map[AssetType]string // Asset types that couldn't be read in full, with the reason
+
+    assets map[AssetRef]*Asset
+    in     map[AssetRef][]Edge
+    out    map[AssetRef][]Edge
+}
+
+func newGraph(mid string) *Graph {
+    return &Graph{
+        MID:        mid,
+        Incomplete: make(map[AssetType]string),
+        assets:     make(map[AssetRef]*Asset),
+        in:         make(map[AssetRef][]Edge),
+        out:        make(map[AssetRef][]Edge),
+    }
+}
+
+func (g *Graph) addAsset(asset Asset) {
+    g.assets[asset.AssetRef] = &asset
+}
+
+func (g *Graph) addEdge(from, to AssetRef, edgeType EdgeType) {
+    edge := Edge{From: from, To: to, Type: edgeType}
+    g.out[from] = append(g.out[from], edge)
+    g.in[to] = append(g.in[to], edge)
+}
+
+// Asset returns the asset, nil when the crawl didn't find it
+func (g *Graph) Asset(ref AssetRef) *Asset {
+    return g.assets[ref]
+}
+
+// Complete reports whether every asset of the types was read, so a missing edge really means there's no reference
+func (g *Graph) Complete(types ...AssetType) bool {
+    for _, assetType := range types {
+        if _, incomplete := g.Incomplete[assetType]; incomplete {
+            return false
+        }
+    }
+    return true
+}
+
+// Sources returns the assets of the given type referencing the asset through the edge, sorted by name
+func (g *Graph) Sources(to AssetRef, edgeType EdgeType, from AssetType) []Asset {
+    var assets []Asset
+    for _, edge := range g.in[to] {
+        if edge.Type == edgeType && edge.From.Type == from {
+            if asset, found := g.assets[edge.From]; found {
+                assets = append(assets, *asset)
+            }
+        }
+    }
+    sortAssets(assets)
+    return assets
+}
+
+// Targets returns the assets of the given type the asset references through the edge, sorted by name
+func (g *Graph) Targets(from AssetRef, edgeType EdgeType, to AssetType) []Asset {
+    var assets []Asset
+    for _, edge := range g.out[from] {
+        if edge.Type == edgeType && edge.To.Type == to {
+            if asset, found := g.assets[edge.To]; found {
+                assets = append(assets, *asset)
+            }
+        }
+    }
+    sortAssets(assets)
+    return assets
+}
+
+// Incoming returns every edge pointing at the asset
+func (g *Graph) Incoming(to AssetRef) []Edge {
+    return g.in[to]
+}
+
+// Outgoing returns every edge leaving the asset
+func (g *Graph) Outgoing(from AssetRef) []Edge {
+    return g.out[from]
+}
+
+// Size returns the number of assets and edges in the graph
+func (g *Graph) Size() (int, int) {
+    edges := 0
+    for _, outgoing := range g.out {
+        edges += len(outgoing)
+    }
+    return len(g.assets), edges
+}
+
+func sortAssets(assets []Asset) {
+    sort.Slice(assets, func(i, j int) bool {
+        if assets[i].Name != assets[j].Name {
+            return assets[i].Name < assets[j].Name
+        }
+        return assets[i].ID < assets[j].ID
+    })
+}
+
+// --- Reference Extraction ---
+
+// CloudPage links in AMPscript, e.g. CloudPagesURL(1234, 'id', @id)
+var cloudPageLinkPattern = regexp.MustCompile(`(?i)CloudPagesURL\(\s*(\d+)`)
+
+// nameIndex finds Data Extensions by their exact name, case-insensitively
+type nameIndex map[string][]AssetRef
+
+func (n nameIndex) add(name string, ref AssetRef) {
+    if name == "" {
+        return
+    }
+    key := strings.ToLower(name)
+    n[key] = append(n[key], ref)
+}
+
+// referenceIndex finds the Data Extensions a text mentions the way the live lookup for that text searches for them,
+// i.e. by a substring matching one of the terms the Data Extension was added with
+type referenceIndex struct {
+    ignoreCase bool
+    entries    []referenceTerms
+}
+
+type referenceTerms struct {
+    ref   AssetRef
+    terms []string
+}
+
+func (r *referenceIndex) add(ref AssetRef, terms ...string) {
+    var kept []string
+    for _, term := range terms {
+        if term == "" {
+            continue
+        }
+        if r.ignoreCase {
+            term = strings.ToLower(term)
+        }
+        kept = append(kept, term)
+    }
+    if len(kept) > 0 {
+        r.entries = append(r.entries, referenceTerms{ref: ref, terms: kept})
+    }
+}
+
+// find returns every Data Extension mentioned in the text, each one once
+func (r *referenceIndex) find(text string) []AssetRef {
+    if r.ignoreCase {
+        text = strings.ToLower(text)
+    }
+
+    var refs []AssetRef
+    for _, entry := range r.entries {
+        for _, term := range entry.terms {
+            if strings.Contains(text, term) {
+                refs = append(refs, entry.ref)
+                break
+            }
+        }
+    }
+    return refs
+}
+
+// cloudPageLinks returns the IDs of the CloudPages linked from the content, each one once
+func cloudPageLinks(content string) []string {
+    seen := make(map[string]bool)
+    var ids []string
+    for _, match := range cloudPageLinkPattern.FindAllStringSubmatch(content, -1) {
+        if !seen[match[1]] {
+            seen[match[1]] = true
+            ids = append(ids, match[1])
+        }
+    }
+    return ids
+}
+
+// --- Impact Analysis ---
+
+// ImpactedAsset is an asset affected by a change to another one, Path holds the edges leading to it from that asset
+type ImpactedAsset struct {
+    Asset
+    Distance int
+    Path     []Edge
+}
+
+// Impact walks up to depth hops from the asset and returns every asset a change to it may break, nearest first. An
+// asset is affected when it references an affected asset, e.g. a query reading a Data Extension or an automation
+// running a query, and a Data Extension is affected when an affected query, import or filter writes to it. Each asset
+// is reported once, at its shortest distance.
+func (g *Graph) Impact(from AssetRef, depth int) []ImpactedAsset {
+    paths := map[AssetRef][]Edge{from: nil}
+    frontier := []AssetRef{from}
+    var impacted []ImpactedAsset
+
+    for distance := 1; distance <= depth && len(frontier) > 0; distance++ {
+        var hop []ImpactedAsset
+        var next []AssetRef
+        for _, ref := range frontier {
+            for _, edge := range g.impactEdges(ref) {
+                reached := edge.From
+                if edge.From == ref {
+                    reached = edge.To
+                }
+                if _, seen := paths[reached]; seen {
+                    continue
+                }
+                // References to assets the crawl didn't find, e.g. a deleted email, lead nowhere
+                asset, found := g.assets[reached]
+                if !found {
+                    continue
+                }
+
+                path := append(slices.Clone(paths[ref]), edge)
+                paths[reached] = path
+                next = append(next, reached)
+                hop = append(hop, ImpactedAsset{Asset: *asset, Distance: distance, Path: path})
+            }
+        }
+
+        sort.Slice(hop, func(i, j int) bool {
+            if hop[i].Type != hop[j].Type {
+                return hop[i].Type < hop[j].Type
+            }
+            if hop[i].Name != hop[j].Name {
+                return hop[i].Name < hop[j].Name
+            }
+            return hop[i].ID < hop[j].ID
+        })
+        impacted = append(impacted, hop...)
+        frontier = next
+    }
+    return impacted
+}
+
+// impactEdges returns the edges a change to the asset travels along: every reference to it, and the Data Extensions
+// it writes to when it's a query, import or filter. The queries, imports and filters writing to a Data Extension
+// don't depend on it, so those references are left out.
+func (g *Graph) impactEdges(ref AssetRef) []Edge {
+    var edges []Edge
+    for _, edge := range g.in[ref] {
+        if ref.Type == AssetDataExtension && edge.Type == EdgeTargets && writesTo(edge.From.Type) {
+            continue
+        }
+        edges = append(edges, edge)
+    }
+    switch ref.Type {
+    case AssetQuery, AssetImport, AssetFilter:
+        for _, edge := range g.out[ref] {
+            if edge.Type == EdgeTargets {
+                edges = append(edges, edge)
+            }
+        }
+    }
+    return edges
+}
+
+// writesTo reports whether a targets edge from the asset type writes to the Data Extension, rather than sending to it
+func writesTo(assetType AssetType) bool {
+    return assetType == AssetQuery || assetType == AssetImport || assetType == AssetFilter
+}
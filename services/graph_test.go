@@ -0,0 +1,88 @@
+package services
+
+import "testing"
+
+func TestImpactSkipsOtherWritersOfATarget(t *testing.T) {
+    x := AssetRef{Type: AssetDataExtension, ID: "x"}
+    y := AssetRef{Type: AssetDataExtension, ID: "y"}
+    z := AssetRef{Type: AssetDataExtension, ID: "z"}
+    q := AssetRef{Type: AssetQuery, ID: "q"}
+    r := AssetRef{Type: AssetQuery, ID: "r"}
+    a := AssetRef{Type: AssetAutomation, ID: "a"}
+    s := AssetRef{Type: AssetSendDefinition, ID: "s"}
+
+    graph := newGraph("1")
+    for _, asset := range []Asset{
+        {AssetRef: x, Name: "X"}, {AssetRef: y, Name: "Y"}, {AssetRef: z, Name: "Z"},
+        {AssetRef: q, Name: "Q"}, {AssetRef: r, Name: "R"}, {AssetRef: a, Name: "A"}, {AssetRef: s, Name: "S"},
+    } {
+        graph.addAsset(asset)
+    }
+    graph.addEdge(q, x, EdgeReads)
+    graph.addEdge(q, y, EdgeTargets)
+    graph.addEdge(r, z, EdgeReads)
+    graph.addEdge(r, y, EdgeTargets)
+    graph.addEdge(a, r, EdgeRuns)
+    graph.addEdge(s, y, EdgeTargets)
+
+    got := make(map[AssetRef]int)
+    for _, impacted := range graph.Impact(x, 10) {
+        got[impacted.AssetRef] = impacted.Distance
+    }
+
+    want := map[AssetRef]int{q: 1, y: 2, s: 3}
+    if len(got) != len(want) {
+        t.Fatalf("Impact(X) = %v, want %v", got, want)
+    }
+    for ref, distance := range want {
+        if got[ref] != distance {
+            t.Errorf("Impact(X) reached %v at %d, want %d", ref, got[ref], distance)
+        }
+    }
+}
+
+func TestImpactFollowsWritesFromAQuery(t *testing.T) {
+    q := AssetRef{Type: AssetQuery, ID: "q"}
+    y := AssetRef{Type: AssetDataExtension, ID: "y"}
+    a := AssetRef{Type: AssetAutomation, ID: "a"}
+
+    graph := newGraph("1")
+    graph.addAsset(Asset{AssetRef: q, Name: "Q"})
+    graph.addAsset(Asset{AssetRef: y, Name: "Y"})
+    graph.addAsset(Asset{AssetRef: a, Name: "A"})
+    graph.addEdge(q, y, EdgeTargets)
+    graph.addEdge(a, q, EdgeRuns)
+
+    impacted := graph.Impact(q, 1)
+    if len(impacted) != 2 {
+        t.Fatalf("Impact(Q) = %v, want the automation and the target", impacted)
+    }
+}
+
+func TestReferenceIndexMatchesLikeTheLiveLookups(t *testing.T) {
+    email := AssetRef{Type: AssetDataExtension, ID: "email"}
+
+    emailReads := &referenceIndex{}
+    emailReads.add(email, `"Email"`)
+    if refs := emailReads.find(`<p>Open this Email in a browser</p>`); len(refs) != 0 {
+        t.Errorf("email content without the quoted name matched %v", refs)
+    }
+    if refs := emailReads.find(`%%[ SET @rows = LookupRows("Email", "Id", @id) ]%%`); len(refs) != 1 {
+        t.Errorf("email content with the quoted name matched %v, want the Data Extension", refs)
+    }
+
+    contentReads := &referenceIndex{}
+    contentReads.add(email, "Email", "email_key")
+    if refs := contentReads.find(`Var rows = DataExtension.Init("email").Rows.Retrieve();`); len(refs) != 0 {
+        t.Errorf("script naming the Data Extension in another case matched %v", refs)
+    }
+    if refs := contentReads.find(`Var rows = DataExtension.Init("email_key").Rows.Retrieve();`); len(refs) != 1 {
+        t.Errorf("script naming the CustomerKey matched %v, want the Data Extension", refs)
+    }
+
+    queryReads := &referenceIndex{ignoreCase: true}
+    queryReads.add(email, "Email")
+    if refs := queryReads.find(`SELECT Id FROM [EMAIL]`); len(refs) != 1 {
+        t.Errorf("query reading the Data Extension in another case matched %v, want the Data Extension", refs)
+    }
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+    "context"
+    "log/slog"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+
+    "asset_relationship_finder/auth"
+)
+
+// --- Per-Tenant API Budget ---
+
+// Default budget for each tenant, and each session's share of its calls in flight. SFMC_RPS, SFMC_MAX_INFLIGHT and
+// SFMC_SESSION_MAX_INFLIGHT override them.
+const (
+    defaultRequestsPerSecond  = 50.0
+    defaultMaxInFlight        = 25
+    defaultSessionMaxInFlight = 10
+)
+
+// Budgets of tenants that haven't made a call for this long are dropped
+const budgetIdleTTL = 10 * time.Minute
+
+// tenantBudget is a token bucket for the request rate plus a slot pool for the calls in flight
+type tenantBudget struct {
+    mu       sync.Mutex
+    tokens   float64
+    rate     float64 // Tokens added per second
+    burst    float64 // Bucket size, one second's worth (at least one call) so a tenant can burst briefly
+    lastFill time.Time
+    lastUsed time.Time
+    inFlight chan struct{}
+}
+
+// sessionShare caps the calls one session may have in flight, so a single heavy user can't take every slot of the
+// tenant's budget and starve the other users of the account
+type sessionShare struct {
+    lastUsed time.Time // Guarded by budgetsMutex
+    inFlight chan struct{}
+}
+
+var (
+    budgets      = make(map[string]*tenantBudget)
+    shares       = make(map[string]*sessionShare)
+    budgetsMutex sync.Mutex
+)
+
+// acquireBudget waits for a free slot in the session's share, then for a free slot and a token in the tenant's
+// budget, the returned function releases both slots
+func acquireBudget(ctx context.Context, sessionID string) (func(), error) {
+    share := shareFor(sessionID)
+    select {
+    case share.inFlight <- struct{}{}:
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+
+    budget := budgetFor(tenantKey(sessionID))
+    select {
+    case budget.inFlight <- struct{}{}:
+    case <-ctx.Done():
+        <-share.inFlight
+        return nil, ctx.Err()
+    }
+
+    if err := budget.take(ctx); err != nil {
+        <-budget.inFlight
+        <-share.inFlight
+        return nil, err
+    }
+
+    return func() {
+        <-budget.inFlight
+        <-share.inFlight
+    }, nil
+}
+
+// tenantKey groups sessions by SFMC account, since SFMC's own limits apply to the whole account
+func tenantKey(sessionID string) string {
+    if enterpriseID := auth.GetEnterpriseID(sessionID); enterpriseID != "" {
+        return "eid:" + enterpriseID
+    }
+    // The identity isn't known yet right after login, budget the session on its own until it is
+    return "session:" + sessionID
+}
+
+// budgetFor returns the tenant's budget, creating it on first use
+func budgetFor(tenant string) *tenantBudget {
+    budgetsMutex.Lock()
+    defer budgetsMutex.Unlock()
+
+    now := time.Now()
+    if budget, found := budgets[tenant]; found {
+        budget.mu.Lock()
+        budget.lastUsed = now
+        budget.mu.Unlock()
+        return budget
+    }
+
+    pruneIdleBudgets(now)
+
+    rate := requestsPerSecond()
+    burst := max(rate, 1)
+    budget := &tenantBudget{
+        tokens:   burst,
+        rate:     rate,
+        burst:    burst,
+        lastFill: now,
+        lastUsed: now,
+        inFlight: make(chan struct{}, maxInFlight()),
+    }
+    budgets[tenant] = budget
+    slog.Debug("Created API budget", "tenant", tenant, "rps", rate, "max_inflight", cap(budget.inFlight))
+    return budget
+}
+
+// shareFor returns the session's share of its tenant's calls in flight, creating it on first use
+func shareFor(sessionID string) *sessionShare {
+    budgetsMutex.Lock()
+    defer budgetsMutex.Unlock()
+
+    now := time.Now()
+    if share, found := shares[sessionID]; found {
+        share.lastUsed = now
+        return share
+    }
+
+    pruneIdleBudgets(now)
+
+    share := &sessionShare{lastUsed: now, inFlight: make(chan struct{}, sessionMaxInFlight())}
+    shares[sessionID] = share
+    return share
+}
+
+// pruneIdleBudgets drops budgets and shares nobody has used for a while, the caller must hold budgetsMutex
+func pruneIdleBudgets(now time.Time) {
+    for tenant, budget := range budgets {
+        budget.mu.Lock()
+        idle := now.Sub(budget.lastUsed) > budgetIdleTTL && len(budget.inFlight) == 0
+        budget.mu.Unlock()
+        if idle {
+            delete(budgets, tenant)
+        }
+    }
+    for sessionID, share := range shares {
+        if now.Sub(share.lastUsed) > budgetIdleTTL && len(share.inFlight) == 0 {
+            delete(shares, sessionID)
+        }
+    }
+}
+
+// take blocks until the bucket has a token and removes it, or until ctx is done
+func (b *tenantBudget) take(ctx context.Context) error {
+    for {
+        b.mu.Lock()
+        now := time.Now()
+        b.tokens += now.Sub(b.lastFill).Seconds() * b.rate
+        if b.tokens > b.burst {
+            b.tokens = b.burst
+        }
+        b.lastFill = now
+
+        if b.tokens >= 1 {
+            b.tokens--
+            b.mu.Unlock()
+            return nil
+        }
+
+        // Sleep just long enough for the next token
+        wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+        b.mu.Unlock()
+        if err := sleep(ctx, wait); err != nil {
+            return err
+        }
+    }
+}
+
+// requestsPerSecond reads SFMC_RPS, the calls per second each tenant may make
+func requestsPerSecond() float64 {
+    if rate, err := strconv.ParseFloat(os.Getenv("SFMC_RPS"), 64); err == nil && rate > 0 {
+        return rate
+    }
+    return defaultRequestsPerSecond
+}
+
+// maxInFlight reads SFMC_MAX_INFLIGHT, the calls each tenant may have open at once
+func maxInFlight() int {
+    if limit, err := strconv.Atoi(os.Getenv("SFMC_MAX_INFLIGHT")); err == nil && limit > 0 {
+        return limit
+    }
+    return defaultMaxInFlight
+}
+
+// sessionMaxInFlight reads SFMC_SESSION_MAX_INFLIGHT, the calls each session may have open at once, at most the
+// tenant's own limit
+func sessionMaxInFlight() int {
+    if limit, err := strconv.Atoi(os.Getenv("SFMC_SESSION_MAX_INFLIGHT")); err == nil && limit > 0 {
+        return min(limit, maxInFlight())
+    }
+    return min(defaultSessionMaxInFlight, maxInFlight())
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// --- Data Lineage ---
+
+// ImportSource is the file an import reads, FileSpec may hold a naming pattern like %%Year%%
+type ImportSource struct {
+    ObjectID    string `xml:"ObjectID"`
+    FileSpec    string `xml:"FileSpec"`
+    LocationKey string `xml:"RetrieveFileTransferLocation>CustomerKey"`
+}
+
+// FilterSource is what a filter activity filters, source type 2 is a Data Extension and the others are lists
+type FilterSource struct {
+    SourceObjectID string `json:"sourceObjectId"`
+    SourceTypeID   int    `json:"sourceTypeId"`
+}
+
+// Tables a query reads: [bracketed], "quoted" or bare names following FROM or JOIN
+var querySourcePattern = regexp.MustCompile(`(?i)\b(?:from|join)\s+(\[[^\[\]\r\n]+\]|"[^"\r\n]+"|[\w.\-]+)`)
+
+// QuerySourceNames returns the names of the tables the query text reads, each one once. Shared Data Extensions keep
+// their ENT. prefix.
+func QuerySourceNames(queryText string) []string {
+    seen := make(map[string]bool)
+    var names []string
+    for _, match := range querySourcePattern.FindAllStringSubmatch(queryText, -1) {
+        name := strings.Trim(match[1], `[]"`)
+        if key := strings.ToLower(name); name != "" && !seen[key] {
+            seen[key] = true
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+// SystemDataView reports a name of one of SFMC's data views, e.g. _Subscribers or _Sent, which hold tracking data
+// no query, import or filter writes to
+func SystemDataView(name string) bool {
+    return strings.HasPrefix(strings.TrimPrefix(strings.ToLower(name), "ent."), "_")
+}
+
+// GetQueryTexts returns the SQL of the queries by ObjectID
+func GetQueryTexts(ctx context.Context, sessionID string, objectIDs []string) (map[string]string, error) {
+    queries, err := retrieveAll[crawledQuery](ctx, sessionID, RetrieveRequest{
+        ObjectType: "QueryDefinition",
+        Properties: []string{"ObjectID", "QueryText"},
+        Filter:     objectIDFilter(objectIDs),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
+        return nil, err
+    }
+
+    texts := make(map[string]string, len(queries))
+    for _, query := range queries {
+        texts[query.ObjectID] = query.QueryText
+    }
+    return texts, err
+}
+
+// GetImportSources returns the files the imports read by ObjectID
+func GetImportSources(ctx context.Context, sessionID string, objectIDs []string) (map[string]ImportSource, error) {
+    imports, err := retrieveAll[ImportSource](ctx, sessionID, RetrieveRequest{
+        ObjectType: "ImportDefinition",
+        Properties: []string{"ObjectID", "FileSpec", "RetrieveFileTransferLocation.CustomerKey"},
+        Filter:     objectIDFilter(objectIDs),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
+        return nil, err
+    }
+
+    sources := make(map[string]ImportSource, len(imports))
+    for _, source := range imports {
+        sources[source.ObjectID] = source
+    }
+    return sources, err
+}
+
+// GetFilterSource returns what the filter activity filters, SOAP doesn't expose it so it's read over REST
+func GetFilterSource(ctx context.Context, sessionID, filterObjectID string) (*FilterSource, error) {
+    bodyBytes, err := restRequest(ctx, sessionID, "GET", fmt.Sprintf("/automation/v1/filters/%s", filterObjectID), nil)
+    if err != nil {
+        return nil, err
+    }
+
+    var source FilterSource
+    if err := json.Unmarshal(bodyBytes, &source); err != nil {
+        return nil, err
+    }
+    return &source, nil
+}
+
+// GetDataExtensionByObjectID returns the Data Extension, nil when there's none in the business unit
+func GetDataExtensionByObjectID(ctx context.Context, sessionID, objectID string) (*DataExtension, error) {
+    dataExtensions, err := GetDataExtensions(ctx, sessionID, Equals("ObjectID", objectID), false)
+    if err != nil && !errors.Is(err, ErrTruncated) {
+        return nil, err
+    }
+    if len(dataExtensions) == 0 {
+        return nil, nil
+    }
+    return &dataExtensions[0], nil
+}
+
+// IsSynchronizedFolder reports whether the folder holds Data Extensions synchronized from Salesforce CRM, which are
+// written by Marketing Cloud Connect rather than by any activity
+func IsSynchronizedFolder(ctx context.Context, sessionID, categoryID string) (bool, error) {
+    folders, err := retrieveAll[Folder](ctx, sessionID, RetrieveRequest{
+        ObjectType: "DataFolder",
+        Properties: []string{"ID", "Name"},
+        Filter:     And(Equals("ID", categoryID), Equals("ContentType", "synchronizeddataextension")),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
+        return false, err
+    }
+    return len(folders) > 0, nil
+}
+
+// Helper function to match one or more ObjectIDs
+func objectIDFilter(objectIDs []string) Filter {
+    if len(objectIDs) == 1 {
+        return Equals("ObjectID", objectIDs[0])
+    }
+    return In("ObjectID", objectIDs...)
+}
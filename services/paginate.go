@@ -0,0 +1,162 @@
+package services
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+)
+
+// --- REST Pagination ---
+
+// Pages fetched at once by Paginate, and items processed at once by forEachIndex, every call still goes through the
+// tenant's API budget
+const pageWorkers = 8
+
+// PageFunc fetches one page (starting at 1) and returns its items with the total number of items across all pages
+type PageFunc[T any] func(ctx context.Context, page int) ([]T, int, error)
+
+// PageError tells which page of a paginated lookup failed
+type PageError struct {
+    Page int
+    Err  error
+}
+
+func (e *PageError) Error() string {
+    return fmt.Sprintf("failed to fetch page %d: %v", e.Page, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+    return e.Err
+}
+
+// Paginate reads the first page to learn the total count, then fetches the remaining pages with at most pageWorkers
+// goroutines. Items are returned in page order. The first page that fails cancels the others and its error is returned.
+func Paginate[T any](ctx context.Context, pageSize int, fetchPage PageFunc[T]) ([]T, error) {
+    firstPage, totalItems, err := fetchPage(ctx, 1)
+    if err != nil {
+        return nil, &PageError{Page: 1, Err: err}
+    }
+    count(ctx, pagesCounted)
+
+    totalPages := (totalItems + pageSize - 1) / pageSize
+    if totalPages <= 1 {
+        return firstPage, nil
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    // Each worker writes only its own page's slot, so no lock is needed and the order is kept
+    pages := make([][]T, totalPages)
+    pages[0] = firstPage
+
+    var wg sync.WaitGroup
+    var failOnce sync.Once
+    var pageErr error
+    jobs := make(chan int)
+
+    for worker := 0; worker < min(pageWorkers, totalPages-1); worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for page := range jobs {
+                items, _, err := fetchPage(ctx, page)
+                if err != nil {
+                    failOnce.Do(func() {
+                        pageErr = &PageError{Page: page, Err: err}
+                        cancel()
+                    })
+                    continue
+                }
+                count(ctx, pagesCounted)
+                pages[page-1] = items
+            }
+        }()
+    }
+
+feed:
+    for page := 2; page <= totalPages; page++ {
+        select {
+        case jobs <- page:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(jobs)
+    wg.Wait()
+
+    if pageErr != nil {
+        return nil, pageErr
+    }
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    var items []T
+    for _, page := range pages {
+        items = append(items, page...)
+    }
+    return items, nil
+}
+
+// forEachIndex calls fn for every index below n with at most pageWorkers goroutines, like Paginate fetches pages.
+// Indexes not started when ctx is done are skipped.
+func forEachIndex(ctx context.Context, n int, fn func(i int)) {
+    var wg sync.WaitGroup
+    indexes := make(chan int)
+
+    for worker := 0; worker < min(pageWorkers, n); worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range indexes {
+                fn(i)
+            }
+        }()
+    }
+
+feed:
+    for i := 0; i < n; i++ {
+        select {
+        case indexes <- i:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(indexes)
+    wg.Wait()
+}
+
+// restPage is the envelope the Content Builder, Journey Builder and Automation Studio REST APIs return pages in
+type restPage[T any] struct {
+    Count int `json:"count"`
+    Items []T `json:"items"`
+}
+
+// getRESTPage runs a REST call and decodes one page of typed items with the total count
+func getRESTPage[T any](ctx context.Context, sessionID, method, path string, body []byte) ([]T, int, error) {
+    bodyBytes, err := restRequest(ctx, sessionID, method, path, body)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    var page restPage[T]
+    if err := json.Unmarshal(bodyBytes, &page); err != nil {
+        return nil, 0, fmt.Errorf("failed to decode page from %s: %w", path, err)
+    }
+    return page.Items, page.Count, nil
+}
+
+// assetQueryPage copies an asset query body and sets the page to read, so pages fetched concurrently never share a map
+func assetQueryPage(requestBody map[string]interface{}, page, pageSize int) map[string]interface{} {
+    body := make(map[string]interface{}, len(requestBody)+1)
+    for key, value := range requestBody {
+        body[key] = value
+    }
+    body["page"] = map[string]interface{}{
+        "page":     page,
+        "pageSize": pageSize,
+    }
+    return body
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestPaginateReturnsItemsInPageOrder(t *testing.T) {
+    tests := []struct {
+        name       string
+        totalItems int
+        pageSize   int
+        wantPages  int
+    }{
+        {"no items", 0, 10, 1},
+        {"one page", 7, 10, 1},
+        {"full last page", 30, 10, 3},
+        {"more pages than workers", 95, 5, 19},
+    }
+    for _, test := range tests {
+        ctx, pages := WithPageCounter(context.Background())
+
+        items, err := Paginate(ctx, test.pageSize, func(ctx context.Context, page int) ([]int, int, error) {
+            // Later pages answer first, the order must come from the page numbers
+            time.Sleep(time.Duration(test.wantPages-page) * time.Millisecond)
+
+            var items []int
+            for item := (page - 1) * test.pageSize; item < min(page*test.pageSize, test.totalItems); item++ {
+                items = append(items, item)
+            }
+            return items, test.totalItems, nil
+        })
+        if err != nil {
+            t.Fatalf("%s: Paginate() failed: %v", test.name, err)
+        }
+
+        if len(items) != test.totalItems {
+            t.Fatalf("%s: Paginate() returned %d items, want %d", test.name, len(items), test.totalItems)
+        }
+        for i, item := range items {
+            if item != i {
+                t.Errorf("%s: item %d is %d, pages came back out of order", test.name, i, item)
+                break
+            }
+        }
+        if pages.Count() != test.wantPages {
+            t.Errorf("%s: counted %d pages, want %d", test.name, pages.Count(), test.wantPages)
+        }
+    }
+}
+
+func TestPaginateCancelsOnTheFirstError(t *testing.T) {
+    errPage := errors.New("page failed")
+
+    tests := []struct {
+        name       string
+        failedPage int
+    }{
+        {"first page", 1},
+        {"second page", 2},
+        {"page after the others started", 5},
+    }
+    for _, test := range tests {
+        var canceled atomic.Int32
+
+        _, err := Paginate(context.Background(), 10, func(ctx context.Context, page int) ([]int, int, error) {
+            if page == test.failedPage {
+                return nil, 0, errPage
+            }
+            if page == 1 {
+                return []int{0}, 200, nil
+            }
+
+            // Every other page only returns once the failed page has canceled it
+            <-ctx.Done()
+            canceled.Add(1)
+            return nil, 0, ctx.Err()
+        })
+
+        var pageErr *PageError
+        if !errors.As(err, &pageErr) || pageErr.Page != test.failedPage || !errors.Is(err, errPage) {
+            t.Errorf("%s: Paginate() error = %v, want page %d's error", test.name, err, test.failedPage)
+        }
+        if test.failedPage > 2 && canceled.Load() == 0 {
+            t.Errorf("%s: no page in flight saw the cancellation", test.name)
+        }
+    }
+}
+
+func TestForEachIndexSkipsIndexesOnceCanceled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    var calls atomic.Int32
+    forEachIndex(ctx, 1000, func(i int) {
+        if calls.Add(1) == 1 {
+            cancel()
+        }
+    })
+
+    if got := calls.Load(); got == 0 || got >= 1000 {
+        t.Errorf("forEachIndex() ran %d of 1000 indexes after canceling, want a few", got)
+    }
+
+    calls.Store(0)
+    forEachIndex(context.Background(), 50, func(i int) {
+        calls.Add(1)
+    })
+    if calls.Load() != 50 {
+        t.Errorf("forEachIndex() ran %d of 50 indexes, want all of them", calls.Load())
+    }
+}
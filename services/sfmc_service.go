@@ -1,13 +1,12 @@
 package services
 
 import (
-    "bytes"
+    "context"
+    "errors"
     "encoding/json"
-    "encoding/xml"
     "fmt"
     "regexp"
-    "io/ioutil"
-    "log"
+    "log/slog"
     "net/http"
     "os"
     "sort"
@@ -15,8 +14,6 @@ import (
     "sync"
     "net/url"
     "time"
-
-     "asset_relationship_finder/auth"
 )
 
 
@@ -28,6 +25,12 @@ type Folder struct {
     ParentName   string `xml:"ParentFolder>Name"`
 }
 
+type BusinessUnit struct {
+    ID           string `xml:"ID" json:"mid"`
+    Name         string `xml:"Name" json:"name"`
+    ParentID     string `xml:"ParentID" json:"parentMID"`
+}
+
 type DataExtension struct {
     CustomerKey  string `xml:"CustomerKey"`
     Name         string `xml:"Name"`
@@ -76,6 +79,12 @@ type Script struct {
     ObjectID   string `json:"ssjsActivityId"`
 }
 
+// Script as listed by the API, with the code used to filter on the Data Extension
+type scriptItem struct {
+    Script
+    Content string `json:"script"`
+}
+
 type EventDefinition struct {
     DataExtensionName string    `json:"dataExtensionName"`
     CreatedDate       Time      `json:"createdDate"`
@@ -104,12 +113,6 @@ type TriggeredSendDefinition struct {
     Name    string `xml:"Name"`
 }
 
-// PageResponse is a generic structure to hold paginated results.
-type PageResponse struct {
-    Count int         `json:"count"`
-    Items interface{} `json:"items"`
-}
-
 // Custom Time type that implements json.Unmarshaler to handle the custom format
 type Time struct {
     time.Time
@@ -140,126 +143,80 @@ func (t *Time) UnmarshalJSON(b []byte) (err error) {
 
 // --- Asset Retrieval Functions ---
 
-func GetDataExtensions(filter string) ([]DataExtension, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
+func GetDataExtensions(ctx context.Context, sessionID string, filter Filter, queryAllAccounts bool) ([]DataExtension, error) {
+    results, err := retrieveAll[DataExtension](ctx, sessionID, RetrieveRequest{
+        ObjectType:       "DataExtension",
+        Properties:       []string{"Name", "CustomerKey", "CategoryID", "ObjectID"},
+        Filter:           filter,
+        QueryAllAccounts: queryAllAccounts,
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "DataExtension", `
-        <Properties>Name</Properties>
-        <Properties>CustomerKey</Properties>
-        <Properties>CategoryID</Properties>
-        <Properties>ObjectID</Properties>
-    `, filter)
+    return results, err
+}
 
-    resp, err := soapRequest(requestBody)
-    if err != nil {
+// GetBusinessUnits retrieves every business unit the session's user can access across the enterprise
+func GetBusinessUnits(ctx context.Context, sessionID string) ([]BusinessUnit, error) {
+    results, err := retrieveAll[BusinessUnit](ctx, sessionID, RetrieveRequest{
+        ObjectType:       "BusinessUnit",
+        Properties:       []string{"ID", "Name", "ParentID"},
+        QueryAllAccounts: true,
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    var response struct {
-        Results []DataExtension `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
-        return nil, err
-    }
+    // Sort by name so the switcher lists the business units in a stable order
+    sort.Slice(results, func(i, j int) bool {
+        return results[i].Name < results[j].Name
+    })
 
-    return response.Results, nil
+    return results, err
 }
 
-func GetActivities(activityObjectID string) ([]Activity, error) {
+func GetActivities(ctx context.Context, sessionID string, activityObjectID string) ([]Activity, error) {
     var activities []Activity
 
-    token, err := auth.GetAccessToken() // Retrieve access token
-    if err != nil {
-        return nil, err
-    }
-
-    // Construct SOAP request body for retrieving activities with the filter for Definition.ObjectID
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "Activity", `
-        <Properties>Name</Properties>
-        <Properties>Program.ObjectID</Properties>
-    `, fmt.Sprintf(`
-        <Filter xsi:type="SimpleFilterPart">
-            <Property>Definition.ObjectID</Property>
-            <SimpleOperator>equals</SimpleOperator>
-            <Value>%s</Value>
-        </Filter>`, activityObjectID))
-
-    // Call the soapRequest function
-    responseBody, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    // Unmarshal and process the response
-    var response struct {
-        Results []Activity `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(responseBody, &response)
-    if err != nil {
+    // Retrieve the activities with the filter for Definition.ObjectID
+    results, err := retrieveAll[Activity](ctx, sessionID, RetrieveRequest{
+        ObjectType: "Activity",
+        Properties: []string{"Name", "Program.ObjectID"},
+        Filter:     Equals("Definition.ObjectID", activityObjectID),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
     // Append the retrieved activities to the slice
-    activities = append(activities, response.Results...)
+    activities = append(activities, results...)
 
-    return activities, nil
+    return activities, err
 }
 
-func GetAutomations(automationObjectID string) ([]Automation, error) {
+func GetAutomations(ctx context.Context, sessionID string, automationObjectID string) ([]Automation, error) {
     var automations []Automation
 
-    token, err := auth.GetAccessToken() // Retrieve access token
-    if err != nil {
-        return nil, err
-    }
-
-    // SOAP request to retrieve Automations (Programs) with the Definition.ObjectID filter
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "Program", `
-        <Properties>Name</Properties>
-        <Properties>ObjectID</Properties>
-    `, fmt.Sprintf(`
-        <Filter xsi:type="SimpleFilterPart">
-            <Property>ObjectID</Property>
-            <SimpleOperator>equals</SimpleOperator>
-            <Value>%s</Value>
-        </Filter>`, automationObjectID))
-
-    // Call the soapRequest function
-    responseBody, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    // Unmarshal the response
-    var response struct {
-        Results []Automation `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(responseBody, &response)
-    if err != nil {
+    // Retrieve the Automations (Programs) with the ObjectID filter
+    results, err := retrieveAll[Automation](ctx, sessionID, RetrieveRequest{
+        ObjectType: "Program",
+        Properties: []string{"Name", "ObjectID"},
+        Filter:     Equals("ObjectID", automationObjectID),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
     // Append the retrieved automations to the slice
-    automations = append(automations, response.Results...)
+    automations = append(automations, results...)
 
-    return automations, nil
+    return automations, err
 }
 
-func GetEmails(deName string, cloudPageID string) ([]Email, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    client := &http.Client{}
+func GetEmails(ctx context.Context, sessionID string, deName string, cloudPageID string) ([]Email, error) {
     pageSize := 50
-    var totalPages int
     var allEmails []Email
-    var mu sync.Mutex
 
      // Construct request body based on whether cloudPageID or deName is provided
     var requestBody map[string]interface{}
@@ -267,10 +224,6 @@ func GetEmails(deName string, cloudPageID string) ([]Email, error) {
     if cloudPageID != "" && deName == "" {
         // Case 1: cloudPageID is provided, deName is empty
         requestBody = map[string]interface{}{
-            "page": map[string]interface{}{
-                "page":     1,
-                "pageSize": pageSize,
-            },
             "query": map[string]interface{}{
                 "leftOperand": map[string]interface{}{
                     "property":      "assetType.name",
@@ -293,10 +246,6 @@ func GetEmails(deName string, cloudPageID string) ([]Email, error) {
     } else if deName != "" && cloudPageID == "" {
         // Case 2: deName is provided, cloudPageID is empty
         requestBody = map[string]interface{}{
-            "page": map[string]interface{}{
-                "page":     1,
-                "pageSize": pageSize,
-            },
             "query": map[string]interface{}{
                 "leftOperand": map[string]interface{}{
                     "leftOperand": map[string]interface{}{
@@ -327,123 +276,25 @@ func GetEmails(deName string, cloudPageID string) ([]Email, error) {
         return nil, fmt.Errorf("either deName or cloudPageID must be provided")
     }
 
-    // Marshal and send the initial request
-    jsonBody, err := json.Marshal(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    req, err := http.NewRequest("POST", fmt.Sprintf("%s/asset/v1/content/assets/query", os.Getenv("REST_ENDPOINT")), bytes.NewBuffer(jsonBody))
-    if err != nil {
-        return nil, err
-    }
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        bodyBytes, _ := ioutil.ReadAll(resp.Body)
-        return nil, fmt.Errorf("non-200 response code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
+    // Read every page of matching emails, each page gets its own copy of the request body
+    items, err := Paginate(ctx, pageSize, func(ctx context.Context, page int) ([]map[string]interface{}, int, error) {
+        jsonBody, err := json.Marshal(assetQueryPage(requestBody, page, pageSize))
+        if err != nil {
+            return nil, 0, err
+        }
+        return getRESTPage[map[string]interface{}](ctx, sessionID, "POST", "/asset/v1/content/assets/query", jsonBody)
+    })
     if err != nil {
         return nil, err
     }
 
-    items, ok := pageResponse.Items.([]interface{})
-    if !ok {
-        return nil, fmt.Errorf("failed to assert pageResponse.Items as []interface{}")
-    }
-
-    mu.Lock()
     processEmailsWrapper(items, &allEmails, deName, cloudPageID)
-    mu.Unlock()
-
-    totalItems := pageResponse.Count
-    totalPages = (totalItems + pageSize - 1) / pageSize
-
-    if totalPages <= 1 {
-        return allEmails, nil
-    }
-
-    var wg sync.WaitGroup
-    for p := 2; p <= totalPages; p++ {
-        wg.Add(1)
-        go func(page int) {
-            defer wg.Done()
-
-            requestBody["page"].(map[string]interface{})["page"] = page
-            jsonBody, err := json.Marshal(requestBody)
-            if err != nil {
-                log.Printf("Error generating request body for page %d: %v", page, err)
-                return
-            }
-
-            req, err := http.NewRequest("POST", fmt.Sprintf("%s/asset/v1/content/assets/query", os.Getenv("REST_ENDPOINT")), bytes.NewBuffer(jsonBody))
-            if err != nil {
-                log.Printf("Error creating request for page %d: %v", page, err)
-                return
-            }
-            req.Header.Set("Content-Type", "application/json")
-            req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-            resp, err := client.Do(req)
-            if err != nil {
-                log.Printf("Error making request for page %d: %v", page, err)
-                return
-            }
-            defer resp.Body.Close()
-
-            if resp.StatusCode != http.StatusOK {
-                bodyBytes, _ := ioutil.ReadAll(resp.Body)
-                log.Printf("Non-200 response code for page %d: %d, body: %s", page, resp.StatusCode, string(bodyBytes))
-                return
-            }
-
-            bodyBytes, err := ioutil.ReadAll(resp.Body)
-            if err != nil {
-                log.Printf("Error reading response body for page %d: %v", page, err)
-                return
-            }
-
-            err = json.Unmarshal(bodyBytes, &pageResponse)
-            if err != nil {
-                log.Printf("Error decoding response for page %d: %v", page, err)
-                return
-            }
-
-            items, ok := pageResponse.Items.([]interface{})
-            if !ok {
-                log.Printf("Failed to assert pageResponse.Items as []interface{} for page %d", page)
-                return
-            }
-
-            mu.Lock()
-            processEmailsWrapper(items, &allEmails, deName, cloudPageID)
-            mu.Unlock()
-
-        }(p)
-    }
-
-    wg.Wait()
     return allEmails, nil
 }
 
 
 // Main function to process emails based on whether cloudPageID is present
-func processEmailsWrapper(items []interface{}, allEmails *[]Email, deName, cloudPageID string) {
+func processEmailsWrapper(items []map[string]interface{}, allEmails *[]Email, deName, cloudPageID string) {
     if cloudPageID != "" {
         // More complex processing if cloudPageID is provided
         processEmailsWithCloudPageID(items, allEmails, cloudPageID)
@@ -454,13 +305,8 @@ func processEmailsWrapper(items []interface{}, allEmails *[]Email, deName, cloud
 }
 
 // Main function to process emails based on whether cloudPageID is present
-func processEmailsWithCloudPageID(items []interface{}, allEmails *[]Email, cloudPageID string) {
-    for _, item := range items {
-        itemMap, ok := item.(map[string]interface{})
-        if !ok {
-            continue
-        }
-
+func processEmailsWithCloudPageID(items []map[string]interface{}, allEmails *[]Email, cloudPageID string) {
+    for _, itemMap := range items {
         // Safely handle the "name" field to check for "EL Content Builder Test"
         emailName, ok := itemMap["name"].(string)
         if !ok {
@@ -476,7 +322,7 @@ func processEmailsWithCloudPageID(items []interface{}, allEmails *[]Email, cloud
             // Safely handle the "id" field which will likely be a float64
             id, ok := itemMap["id"].(float64)
             if !ok {
-                log.Printf("Skipping invalid ID type for item: %v", itemMap["id"])
+                slog.Warn("Skipping invalid ID type for item", "id", itemMap["id"])
                 continue
             }
 
@@ -490,17 +336,17 @@ func processEmailsWithCloudPageID(items []interface{}, allEmails *[]Email, cloud
 }
 
 // Simple processing function when no cloudPageID is provided
-func processSimpleEmails(items []interface{}, allEmails *[]Email) {
+func processSimpleEmails(items []map[string]interface{}, allEmails *[]Email) {
     for _, item := range items {
         emailData, err := json.Marshal(item)
         if err != nil {
-            log.Printf("Error marshalling item: %v", err)
+            slog.Error("Error marshalling item", "error", err)
             continue
         }
 
         var email Email
         if err := json.Unmarshal(emailData, &email); err != nil {
-            log.Printf("Error unmarshalling to Email: %v", err)
+            slog.Error("Error unmarshalling to Email", "error", err)
             continue
         }
 
@@ -534,15 +380,11 @@ func combineAllContent(data interface{}) string {
     return combinedContent
 }
 
-// The function that retrieves the email when email name or ID submitted
-func GetEmailByIDOrName(emailID string, emailName string) (*Email, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    client := &http.Client{}
+// ErrEmailNotFound is returned when no Content Builder email has the ID or name looked up
+var ErrEmailNotFound = errors.New("no email found with the provided ID or Name")
 
+// The function that retrieves the email when email name or ID submitted
+func GetEmailByIDOrName(ctx context.Context, sessionID string, emailID string, emailName string) (*Email, error) {
     // Construct request body based on whether EmailID or EmailName is provided
     var requestBody map[string]interface{}
     if emailID != "" {
@@ -583,61 +425,23 @@ func GetEmailByIDOrName(emailID string, emailName string) (*Email, error) {
         return nil, err
     }
 
-    log.Printf("Request Body: %s", string(jsonBody))
-
-    req, err := http.NewRequest("POST", fmt.Sprintf("%s/asset/v1/content/assets/query", os.Getenv("REST_ENDPOINT")), bytes.NewBuffer(jsonBody))
-    if err != nil {
-        return nil, err
-    }
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+    slog.DebugContext(ctx, "Email query request", "body", string(jsonBody))
 
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        bodyBytes, _ := ioutil.ReadAll(resp.Body)
-        return nil, fmt.Errorf("non-200 response code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
+    items, _, err := getRESTPage[map[string]interface{}](ctx, sessionID, "POST", "/asset/v1/content/assets/query", jsonBody)
     if err != nil {
         return nil, err
     }
 
     // Process the response and return the email
-    items, ok := pageResponse.Items.([]interface{})
-    if !ok || len(items) == 0 {
-        return nil, fmt.Errorf("no email found with the provided ID or Name")
+    if len(items) == 0 {
+        return nil, ErrEmailNotFound
     }
 
     // Extract data.email.legacy.legacyId manually
-    itemMap, ok := items[0].(map[string]interface{})
-    if !ok {
-        return nil, fmt.Errorf("failed to parse item")
-    }
+    itemMap := items[0]
 
     // Retrieve the legacyId from the nested structure
-    legacyId := ""
-    if data, ok := itemMap["data"].(map[string]interface{}); ok {
-        if emailData, ok := data["email"].(map[string]interface{}); ok {
-            if legacy, ok := emailData["legacy"].(map[string]interface{}); ok {
-                if id, ok := legacy["legacyId"].(float64); ok {
-                    legacyId = fmt.Sprintf("%.0f", id) // Convert to string
-                }
-            }
-        }
-    }
-
+    legacyId := legacyEmailID(itemMap)
     if legacyId == "" {
         return nil, fmt.Errorf("failed to retrieve legacyId")
     }
@@ -651,105 +455,56 @@ func GetEmailByIDOrName(emailID string, emailName string) (*Email, error) {
     return &email, nil
 }
 
-func GetJourneys(deName string, emailID string) ([]Journey, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
+// legacyEmailID reads data.email.legacy.legacyId, the ID journeys and sends refer to an email by
+func legacyEmailID(itemMap map[string]interface{}) string {
+    if data, ok := itemMap["data"].(map[string]interface{}); ok {
+        if emailData, ok := data["email"].(map[string]interface{}); ok {
+            if legacy, ok := emailData["legacy"].(map[string]interface{}); ok {
+                if id, ok := legacy["legacyId"].(float64); ok {
+                    return fmt.Sprintf("%.0f", id) // Convert to string
+                }
+            }
+        }
     }
+    return ""
+}
 
+func GetJourneys(ctx context.Context, sessionID string, deName string, emailID string) ([]Journey, error) {
     if deName != "" {
         // Use structured processing with Journey structs when deName is provided
-        return getJourneysByDeName(token, deName)
+        return getJourneysByDeName(ctx, sessionID, deName)
     } else if emailID != "" {
         // Use dynamic processing with emailID filtering
-        return getJourneysByEmailID(token, emailID)
+        return getJourneysByEmailID(ctx, sessionID, emailID)
     }
 
     return nil, fmt.Errorf("both deName and emailID cannot be empty")
 }
 
 // This function handles the case when deName is provided
-func getJourneysByDeName(token, deName string) ([]Journey, error) {
-    var allJourneys []Journey
-    var mu sync.Mutex
-
-    // Fetch the first page to determine the total count
-    firstPageJourneys, totalItems, err := fetchJourneyPageStructured(token, 1, 50)
+func getJourneysByDeName(ctx context.Context, sessionID, deName string) ([]Journey, error) {
+    // Fetch every page of journeys
+    allJourneys, err := Paginate(ctx, 50, func(ctx context.Context, page int) ([]Journey, int, error) {
+        return fetchJourneyPageStructured(ctx, sessionID, page, 50)
+    })
     if err != nil {
         return nil, err
     }
 
-    allJourneys = append(allJourneys, firstPageJourneys...)
-
-    // Calculate total number of pages
-    totalPages := (totalItems + 50 - 1) / 50
-
-    results := make(chan []Journey, totalPages-1)
-
-    // Fetch remaining pages concurrently
-    for page := 2; page <= totalPages; page++ {
-        go func(page int) {
-            journeys, _, err := fetchJourneyPageStructured(token, page, 50)
-            if err != nil {
-                log.Printf("Error fetching page %d: %v", page, err)
-                results <- []Journey{}
-                return
-            }
-            results <- journeys
-        }(page)
-    }
-
-    // Collect remaining pages' results
-    for page := 2; page <= totalPages; page++ {
-        journeys := <-results
-        mu.Lock()
-        allJourneys = append(allJourneys, journeys...)
-        mu.Unlock()
-    }
-
     // Process journeys and filter by deName
-    return processJourneysAndFetchEventDefinitions(allJourneys, token, deName), nil
+    return processJourneysAndFetchEventDefinitions(ctx, allJourneys, sessionID, deName), nil
 }
 
 // This function handles the case when emailID is provided
-func getJourneysByEmailID(token, emailID string) ([]Journey, error) {
-    var dynamicJourneys []map[string]interface{}
-    var mu sync.Mutex
-
-    // Fetch the first page to determine the total count
-    firstPageJourneys, totalItems, err := fetchJourneyPageDynamic(token, 1, 50, true)
+func getJourneysByEmailID(ctx context.Context, sessionID, emailID string) ([]Journey, error) {
+    // Fetch every page of journeys along with their activities
+    dynamicJourneys, err := Paginate(ctx, 50, func(ctx context.Context, page int) ([]map[string]interface{}, int, error) {
+        return fetchJourneyPageDynamic(ctx, sessionID, page, 50, true)
+    })
     if err != nil {
         return nil, err
     }
 
-    dynamicJourneys = append(dynamicJourneys, firstPageJourneys...)
-
-    // Calculate total number of pages
-    totalPages := (totalItems + 50 - 1) / 50
-
-    results := make(chan []map[string]interface{}, totalPages-1)
-
-    // Fetch remaining pages concurrently
-    for page := 2; page <= totalPages; page++ {
-        go func(page int) {
-            journeys, _, err := fetchJourneyPageDynamic(token, page, 50, true)
-            if err != nil {
-                log.Printf("Error fetching page %d: %v", page, err)
-                results <- []map[string]interface{}{}
-                return
-            }
-            results <- journeys
-        }(page)
-    }
-
-    // Collect remaining pages' results
-    for page := 2; page <= totalPages; page++ {
-        journeys := <-results
-        mu.Lock()
-        dynamicJourneys = append(dynamicJourneys, journeys...)
-        mu.Unlock()
-    }
-
     // Filter dynamic journeys by emailID
     filteredJourneys := filterJourneysByEmailID(dynamicJourneys, emailID)
     var result []Journey
@@ -764,154 +519,75 @@ func getJourneysByEmailID(token, emailID string) ([]Journey, error) {
 }
 
 // Structured journey fetch for deName case
-func fetchJourneyPageStructured(token string, page, pageSize int) ([]Journey, int, error) {
-    client := &http.Client{}
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/interaction/v1/interactions?$page=%d&$pageSize=%d", os.Getenv("REST_ENDPOINT"), page, pageSize), nil)
-    if err != nil {
-        return nil, 0, err
-    }
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, 0, err
+func fetchJourneyPageStructured(ctx context.Context, sessionID string, page, pageSize int) ([]Journey, int, error) {
+    // Journeys as listed, with the default email used to find the entry event
+    type journeyItem struct {
+        Journey
+        Defaults struct {
+            Email []string `json:"email"`
+        } `json:"defaults"`
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        return nil, 0, fmt.Errorf("non-200 response: %d", resp.StatusCode)
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
+    items, totalItems, err := getRESTPage[journeyItem](ctx, sessionID, "GET", fmt.Sprintf("/interaction/v1/interactions?$page=%d&$pageSize=%d", page, pageSize), nil)
     if err != nil {
         return nil, 0, err
     }
 
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, 0, err
-    }
+    journeys := make([]Journey, len(items))
+    for i, item := range items {
+        journeys[i] = item.Journey
 
-    journeys := make([]Journey, len(pageResponse.Items.([]interface{})))
-    for i, item := range pageResponse.Items.([]interface{}) {
-        journeyData, _ := json.Marshal(item)
-        json.Unmarshal(journeyData, &journeys[i])
-
-        journeyMap := item.(map[string]interface{})
-        
-        // Extract EventDefinitionKey from nested structure
-        if defaults, ok := journeyMap["defaults"].(map[string]interface{}); ok {
-            if emails, ok := defaults["email"].([]interface{}); ok && len(emails) > 0 {
-                if email, ok := emails[0].(string); ok {
-                    journeys[i].EventDefinitionKey = extractEventDefinitionKey(email)
-                }
-            }
+        // Extract EventDefinitionKey from the default email
+        if len(item.Defaults.Email) > 0 {
+            journeys[i].EventDefinitionKey = extractEventDefinitionKey(item.Defaults.Email[0])
         }
     }
 
-    return journeys, pageResponse.Count, nil
+    return journeys, totalItems, nil
 }
 
 // Dynamic journey fetch for emailID case
-func fetchJourneyPageDynamic(token string, page, pageSize int, includeActivities bool) ([]map[string]interface{}, int, error) {
-    client := &http.Client{}
-    url := fmt.Sprintf("%s/interaction/v1/interactions?$page=%d&$pageSize=%d", os.Getenv("REST_ENDPOINT"), page, pageSize)
+func fetchJourneyPageDynamic(ctx context.Context, sessionID string, page, pageSize int, includeActivities bool) ([]map[string]interface{}, int, error) {
+    path := fmt.Sprintf("/interaction/v1/interactions?$page=%d&$pageSize=%d", page, pageSize)
     if includeActivities {
-        url += "&extras=activities"
-    }
-
-    req, err := http.NewRequest("GET", url, nil)
-    if err != nil {
-        return nil, 0, err
+        path += "&extras=activities"
     }
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, 0, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, 0, fmt.Errorf("non-200 response: %d", resp.StatusCode)
-    }
-
-    var pageResponse map[string]interface{}
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, 0, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, 0, err
-    }
-
-    totalItems := int(pageResponse["count"].(float64))
-    journeys := pageResponse["items"].([]interface{})
-
-    mappedJourneys := make([]map[string]interface{}, len(journeys))
-    for i, journey := range journeys {
-        mappedJourneys[i] = journey.(map[string]interface{})
-    }
-
-    return mappedJourneys, totalItems, nil
+    return getRESTPage[map[string]interface{}](ctx, sessionID, "GET", path, nil)
 }
 
 // processJourneysAndFetchEventDefinitions for deName logic
-func processJourneysAndFetchEventDefinitions(journeys []Journey, token, deName string) []Journey {
+func processJourneysAndFetchEventDefinitions(ctx context.Context, journeys []Journey, sessionID, deName string) []Journey {
     var mu sync.Mutex
-    var wg sync.WaitGroup
     filteredJourneys := make([]Journey, 0, len(journeys))
 
-    // Semaphore to limit concurrent event definition fetching to 50
-    semaphore := make(chan struct{}, 50)
-
-    log.Printf("Starting to process %d journeys", len(journeys))
+    slog.DebugContext(ctx, "Starting to process journeys", "count", len(journeys))
 
     totalJourneys := len(journeys)
     if totalJourneys == 0 {
         return filteredJourneys
     }
 
-    // Iterate over all the journeys and add a delay after processing every 50 journeys
-    for i, journey := range journeys {
-        // Add to the wait group for concurrent execution
-        wg.Add(1)
-        go func(journey Journey) {
-            defer wg.Done()
-
-            // Semaphore: Wait if there are already 50 concurrent tasks
-            semaphore <- struct{}{}
-            defer func() { <-semaphore }() // Release semaphore after task completion
-
-            // Fetch event definition for each journey
-            eventDef, err := fetchEventDefinition(token, journey.EventDefinitionKey, journey.Name)
-            if err != nil {
-                log.Printf("Error fetching event definition for journey %s: %v", journey.Name, err)
-                return
-            }
+    // Look up the event definitions with a fixed number of workers, the tenant's API budget paces their calls
+    forEachIndex(ctx, totalJourneys, func(i int) {
+        journey := journeys[i]
 
-            // Check if the event definition matches the provided Data Extension name
-            if eventDef != nil && eventDef.DataExtensionName == deName {
-                mu.Lock()
-                filteredJourneys = append(filteredJourneys, journey)
-                mu.Unlock()
-            }
-        }(journey)
-
-        // Introduce a delay after processing every 50 journeys to control the flow
-        if (i+1)%50 == 0 {
-            log.Printf("Processed %d journeys, introducing delay...", i+1)
-            time.Sleep(500 * time.Millisecond)
+        // Fetch event definition for each journey
+        eventDef, err := fetchEventDefinition(ctx, sessionID, journey.EventDefinitionKey, journey.Name)
+        if err != nil {
+            slog.WarnContext(ctx, "Error fetching event definition", "journey", journey.Name, "error", err)
+            return
         }
-    }
 
-    // Wait for all goroutines to finish
-    wg.Wait()
+        // Check if the event definition matches the provided Data Extension name
+        if eventDef != nil && eventDef.DataExtensionName == deName {
+            mu.Lock()
+            filteredJourneys = append(filteredJourneys, journey)
+            mu.Unlock()
+        }
+    })
 
-    log.Printf("Finished processing journeys. Total filtered journeys: %d", len(filteredJourneys))
+    slog.DebugContext(ctx, "Finished processing journeys", "matched", len(filteredJourneys))
     return filteredJourneys
 }
 
@@ -923,47 +599,47 @@ func filterJourneysByEmailID(journeys []map[string]interface{}, emailID string)
     for _, journey := range journeys {
         // Check if "activities" exist in the journey map
         if activities, ok := journey["activities"].([]interface{}); ok {
-            log.Printf("Found %d activities in journey: %s\n", len(activities), journey["name"])
+            slog.Debug("Found activities in journey", "count", len(activities), "journey", journey["name"])
 
             for _, activity := range activities {
                 activityMap, ok := activity.(map[string]interface{})
                 if !ok {
-                    log.Println("Error: Activity is not in expected map format.")
+                    slog.Debug("Activity is not in expected map format", "journey", journey["name"])
                     continue
                 }
 
-                log.Printf("Checking activity type: %v\n", activityMap["type"])
+                slog.Debug("Checking activity", "type", activityMap["type"])
 
                 // Look for "EMAILV2" activity type and match the email ID
                 if activityMap["type"] == "EMAILV2" {
                     configArgs, ok := activityMap["configurationArguments"].(map[string]interface{})
                     if !ok {
-                        log.Println("Error: configurationArguments not found or not in expected format.")
+                        slog.Debug("configurationArguments not found or not in expected format", "journey", journey["name"])
                         continue
                     }
 
                     triggeredSend, ok := configArgs["triggeredSend"].(map[string]interface{})
                     if !ok {
-                        log.Println("Error: triggeredSend not found or not in expected format.")
+                        slog.Debug("triggeredSend not found or not in expected format", "journey", journey["name"])
                         continue
                     }
 
                     // Check if the emailID matches
                     if emailIDFromActivity, ok := triggeredSend["emailId"].(float64); ok {
-                        log.Printf("Found emailId in activity: %.0f", emailIDFromActivity)
+                        slog.Debug("Found emailId in activity", "email_id", fmt.Sprintf("%.0f", emailIDFromActivity))
 
                         if fmt.Sprintf("%.0f", emailIDFromActivity) == emailID {
-                            log.Printf("Match found for emailID: %s in journey: %s", emailID, journey["name"])
+                            slog.Debug("Match found for emailID", "email_id", emailID, "journey", journey["name"])
                             filteredJourneys = append(filteredJourneys, journey)
                             break // Stop checking other activities if we found a match
                         }
                     } else {
-                        log.Println("Error: emailId not found or not in expected format.")
+                        slog.Debug("emailId not found or not in expected format", "journey", journey["name"])
                     }
                 }
             }
         } else {
-            log.Printf("No activities found in journey: %s\n", journey["name"])
+            slog.Debug("No activities found in journey", "journey", journey["name"])
         }
     }
 
@@ -971,77 +647,31 @@ func filterJourneysByEmailID(journeys []map[string]interface{}, emailID string)
 }
 
 // fetchEventDefinition first tries to fetch event definition by key, then falls back to name if needed
-func fetchEventDefinition(token, eventDefinitionKey, journeyName string) (*EventDefinition, error) {
+func fetchEventDefinition(ctx context.Context, sessionID, eventDefinitionKey, journeyName string) (*EventDefinition, error) {
     // Try fetching by eventDefinitionKey first
-    eventDef, err := fetchEventDefinitionByKey(token, eventDefinitionKey)
+    eventDef, err := fetchEventDefinitionByKey(ctx, sessionID, eventDefinitionKey)
     if err == nil && eventDef != nil {
-        log.Printf("Found event definition by key: %s", eventDefinitionKey)
+        slog.DebugContext(ctx, "Found event definition by key", "event_definition_key", eventDefinitionKey)
         return eventDef, nil
     }
 
-    log.Printf("No match found by key: %s, trying by journey name: %s", eventDefinitionKey, journeyName)
+    slog.DebugContext(ctx, "No event definition found by key, trying by journey name", "event_definition_key", eventDefinitionKey, "journey", journeyName)
 
     // Remove anything after the first occurrence of '[' or '{' from the journey name
     sanitizedJourneyName := url.QueryEscape(sanitizeJourneyName(journeyName))
 
     // Try searching by the journey name
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/interaction/v1/eventDefinitions?name=%s", os.Getenv("REST_ENDPOINT"), sanitizedJourneyName), nil)
-    if err != nil {
-        return nil, err
-    }
-
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+    path := fmt.Sprintf("/interaction/v1/eventDefinitions?name=%s", sanitizedJourneyName)
+    slog.DebugContext(ctx, "Event definition request", "path", path)
 
-    log.Printf("Request URL: %s", req.URL)
-
-    // Perform the request
-    client := &http.Client{}
-    resp, err := client.Do(req)
+    eventDefs, _, err := getRESTPage[EventDefinition](ctx, sessionID, "GET", path, nil)
     if err != nil {
         return nil, err
     }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("non-200 status code returned: %d", resp.StatusCode)
-    }
-
-    // Decode response body using PageResponse
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, err
-    }
-
-    // Convert Items to []interface{} and then map it to []EventDefinition
-    items, ok := pageResponse.Items.([]interface{})
-    if !ok {
-        return nil, fmt.Errorf("unable to parse items into []interface{}")
-    }
-
-    var eventDefs []EventDefinition
-    for _, item := range items {
-        itemBytes, err := json.Marshal(item)
-        if err != nil {
-            return nil, err
-        }
-
-        var eventDef EventDefinition
-        err = json.Unmarshal(itemBytes, &eventDef)
-        if err != nil {
-            return nil, err
-        }
-        eventDefs = append(eventDefs, eventDef)
-    }
 
     // If we found results, order them by createdDate and return the newest one
     if len(eventDefs) > 0 {
-        log.Printf("Found %d event definitions by name. Sorting by createdDate...", len(eventDefs))
+        slog.DebugContext(ctx, "Found event definitions by name, sorting by createdDate", "count", len(eventDefs))
 
         // Sort by createdDate (descending to get the newest one first)
         sort.Slice(eventDefs, func(i, j int) bool {
@@ -1056,31 +686,13 @@ func fetchEventDefinition(token, eventDefinitionKey, journeyName string) (*Event
 }
 
 // fetchEventDefinitionByKey fetches the event definition by event key
-func fetchEventDefinitionByKey(token, eventDefinitionKey string) (*EventDefinition, error) {
-    client := &http.Client{}
-
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/interaction/v1/eventDefinitions/key:%s", os.Getenv("REST_ENDPOINT"), eventDefinitionKey), nil)
+func fetchEventDefinitionByKey(ctx context.Context, sessionID, eventDefinitionKey string) (*EventDefinition, error) {
+    bodyBytes, err := restRequest(ctx, sessionID, "GET", fmt.Sprintf("/interaction/v1/eventDefinitions/key:%s", eventDefinitionKey), nil)
     if err != nil {
         return nil, err
     }
 
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("non-200 status code returned: %d", resp.StatusCode)
-    }
-
     var eventDef EventDefinition
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, err
-    }
 
     err = json.Unmarshal(bodyBytes, &eventDef)
     if err != nil {
@@ -1103,288 +715,80 @@ func sanitizeJourneyName(name string) string {
     return name // Return the full name if no special character is found
 }
 
-func GetScripts(deName, deCustomerKey, scriptName string) ([]Script, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    var allScripts []Script
-    var mu sync.Mutex
-
+func GetScripts(ctx context.Context, sessionID string, deName, deCustomerKey, scriptName string) ([]Script, error) {
     // If scriptName is provided, fetch the scripts with a name filter
     if scriptName != "" {
-        filteredScripts, err := fetchScriptsByName(token, scriptName)
+        filteredScripts, err := fetchScriptsByName(ctx, sessionID, scriptName)
         if err != nil {
             return nil, err
         }
         return filteredScripts, nil
     }
 
-    // Fetch the first page to determine the total count (for deName and deCustomerKey case)
-    firstPageScripts, totalItems, rawItems, err := fetchScriptPage(token, 1, 50)
+    // Fetch every page of scripts (for deName and deCustomerKey case)
+    scripts, err := Paginate(ctx, 50, func(ctx context.Context, page int) ([]scriptItem, int, error) {
+        return fetchScriptPage(ctx, sessionID, page, 50)
+    })
     if err != nil {
         return nil, err
     }
 
-    // Use the scripts from the first page directly
-    allPages := [][]Script{firstPageScripts}
-    allRawItems := [][]interface{}{rawItems}
-
-    // Calculate total number of pages
-    totalPages := (totalItems + 50 - 1) / 50
-
-    results := make(chan []Script, totalPages-1)
-    rawResults := make(chan []interface{}, totalPages-1)
-
-    // Fetch remaining pages concurrently
-    for page := 2; page <= totalPages; page++ {
-        go func(page int) {
-            scripts, _, rawItems, err := fetchScriptPage(token, page, 50)
-            if err != nil {
-                log.Printf("Error fetching page %d: %v", page, err)
-                results <- []Script{}
-                rawResults <- []interface{}{}
-                return
-            }
-            results <- scripts
-            rawResults <- rawItems
-        }(page)
-    }
-
-    // Collect remaining pages' results
-    for page := 2; page <= totalPages; page++ {
-        scripts := <-results
-        rawItems := <-rawResults
-        allPages = append(allPages, scripts)
-        allRawItems = append(allRawItems, rawItems)
-    }
-
-    // Process all pages' scripts and filter them using deName and deCustomerKey
-    for i, scripts := range allPages {
-        rawItems := allRawItems[i]
-        filteredScripts := processScripts(scripts, deName, deCustomerKey, rawItems)
-        mu.Lock()
-        allScripts = append(allScripts, filteredScripts...)
-        mu.Unlock()
-    }
-
-    return allScripts, nil
+    // Filter the scripts using deName and deCustomerKey
+    return processScripts(scripts, deName, deCustomerKey), nil
 }
 
 // Function to fetch scripts by script name
-func fetchScriptsByName(token, scriptName string) ([]Script, error) {
-    client := &http.Client{}
-    // Fetch scripts filtered by name
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/automation/v1/scripts?$filter=name%%20eq%%20%v", os.Getenv("REST_ENDPOINT"), scriptName), nil)
-    if err != nil {
-        return nil, err
-    }
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-    resp, err := client.Do(req)
+func fetchScriptsByName(ctx context.Context, sessionID, scriptName string) ([]Script, error) {
+    // Fetch scripts filtered by name, escaped so characters like & or # can't change the filter
+    scripts, _, err := getRESTPage[Script](ctx, sessionID, "GET", fmt.Sprintf("/automation/v1/scripts?$filter=name%%20eq%%20%s", url.QueryEscape(scriptName)), nil)
     if err != nil {
         return nil, err
     }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("non-200 response: %d", resp.StatusCode)
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, err
-    }
-
-    scripts := make([]Script, len(pageResponse.Items.([]interface{})))
-    for i, item := range pageResponse.Items.([]interface{}) {
-        scriptData, _ := json.Marshal(item)
-        json.Unmarshal(scriptData, &scripts[i])
-    }
 
     return scripts, nil
 }
 
 // Function to fetch pages for script API responses
-func fetchScriptPage(token string, page, pageSize int) ([]Script, int, []interface{}, error) {
-    client := &http.Client{}
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/automation/v1/scripts?$page=%d&$pageSize=%d", os.Getenv("REST_ENDPOINT"), page, pageSize), nil)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, 0, nil, fmt.Errorf("non-200 response: %d", resp.StatusCode)
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-
-    scripts := make([]Script, len(pageResponse.Items.([]interface{})))
-    for i, item := range pageResponse.Items.([]interface{}) {
-        scriptData, _ := json.Marshal(item)
-        json.Unmarshal(scriptData, &scripts[i])
-    }
-
-    return scripts, pageResponse.Count, pageResponse.Items.([]interface{}), nil
+func fetchScriptPage(ctx context.Context, sessionID string, page, pageSize int) ([]scriptItem, int, error) {
+    return getRESTPage[scriptItem](ctx, sessionID, "GET", fmt.Sprintf("/automation/v1/scripts?$page=%d&$pageSize=%d", page, pageSize), nil)
 }
 
 // Function to fetch pages for script API responses
-func processScripts(scripts []Script, deName, deCustomerKey string, rawItems []interface{}) []Script {
+func processScripts(scripts []scriptItem, deName, deCustomerKey string) []Script {
     var filteredScripts []Script
 
-    for i, script := range scripts {
-        // Access the raw item corresponding to this script
-        itemMap, ok := rawItems[i].(map[string]interface{})
-        if !ok {
-            continue
-        }
-
-        // Check if the "script" field exists and is a string
-        scriptContent, ok := itemMap["script"].(string)
-        if !ok {
-            continue
-        }
-
+    for _, script := range scripts {
         // Check if the "script" field contains the deName and deCustomerKey
-        if strings.Contains(scriptContent, deName) || strings.Contains(scriptContent, deCustomerKey) {
-            filteredScripts = append(filteredScripts, script)
+        if strings.Contains(script.Content, deName) || strings.Contains(script.Content, deCustomerKey) {
+            filteredScripts = append(filteredScripts, script.Script)
         }
     }
 
     return filteredScripts
 }
 
-func GetCloudPages(deName, deCustomerKey, cloudPageID string) ([]CloudPage, error) {
-    token, err := auth.GetAccessToken()
+func GetCloudPages(ctx context.Context, sessionID string, deName, deCustomerKey, cloudPageID string) ([]CloudPage, error) {
+    // Fetch every page of CloudPages
+    items, err := Paginate(ctx, 50, func(ctx context.Context, page int) ([]map[string]interface{}, int, error) {
+        return fetchCloudPage(ctx, sessionID, page, 50)
+    })
     if err != nil {
         return nil, err
     }
 
-    var allCloudPages []CloudPage
-    var mu sync.Mutex
-
-    // Fetch the first page to determine the total count
-    firstPageCloudPages, totalItems, rawItems, err := fetchCloudPage(token, 1, 50)
-    if err != nil {
-        return nil, err
-    }
-
-    // Use the CloudPages from the first page directly
-    allPages := [][]CloudPage{firstPageCloudPages}
-    allRawItems := [][]interface{}{rawItems}
-
-    // Calculate total number of pages
-    totalPages := (totalItems + 50 - 1) / 50
-
-    results := make(chan []CloudPage, totalPages-1)
-    rawResults := make(chan []interface{}, totalPages-1)
-
-    // Fetch remaining pages concurrently
-    for page := 2; page <= totalPages; page++ {
-        go func(page int) {
-            cloudPages, _, rawItems, err := fetchCloudPage(token, page, 50)
-            if err != nil {
-                log.Printf("Error fetching page %d: %v", page, err)
-                results <- []CloudPage{}
-                rawResults <- []interface{}{}
-                return
-            }
-            results <- cloudPages
-            rawResults <- rawItems
-        }(page)
-    }
-
-    // Collect remaining pages' results
-    for page := 2; page <= totalPages; page++ {
-        cloudPages := <-results
-        rawItems := <-rawResults
-        allPages = append(allPages, cloudPages)
-        allRawItems = append(allRawItems, rawItems)
-    }
-
-    // Process all pages' CloudPages and filter them
-    for i, cloudPages := range allPages {
-        rawItems := allRawItems[i]
-        filteredCloudPages := processCloudPages(cloudPages, deName, deCustomerKey, cloudPageID, rawItems)
-        mu.Lock()
-        allCloudPages = append(allCloudPages, filteredCloudPages...)
-        mu.Unlock()
-    }
-
-    return allCloudPages, nil
+    // Filter the CloudPages on their content
+    return processCloudPages(items, deName, deCustomerKey, cloudPageID), nil
 }
 
-func fetchCloudPage(token string, page, pageSize int) ([]CloudPage, int, []interface{}, error) {
-    client := &http.Client{}
-
+func fetchCloudPage(ctx context.Context, sessionID string, page, pageSize int) ([]map[string]interface{}, int, error) {
     // Generate the request body for the POST request
     requestBody, err := generateCloudPageRequestBody(page, pageSize)
     if err != nil {
-        return nil, 0, nil, err
-    }
-
-    req, err := http.NewRequest(
-        "POST",
-        fmt.Sprintf("%s/asset/v1/content/assets/query", os.Getenv("REST_ENDPOINT")),
-        bytes.NewBuffer(requestBody),
-    )
-    if err != nil {
-        return nil, 0, nil, err
-    }
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-    req.Header.Set("Content-Type", "application/json")
-
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return nil, 0, nil, fmt.Errorf("non-200 response: %d", resp.StatusCode)
-    }
-
-    var pageResponse PageResponse
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-
-    err = json.Unmarshal(bodyBytes, &pageResponse)
-    if err != nil {
-        return nil, 0, nil, err
-    }
-
-    cloudPages := make([]CloudPage, len(pageResponse.Items.([]interface{})))
-    for i, item := range pageResponse.Items.([]interface{}) {
-        cloudPageData, _ := json.Marshal(item)
-        json.Unmarshal(cloudPageData, &cloudPages[i])
+        return nil, 0, err
     }
 
-    return cloudPages, pageResponse.Count, pageResponse.Items.([]interface{}), nil
+    // Items stay raw maps, the content to search is spread over nested views
+    return getRESTPage[map[string]interface{}](ctx, sessionID, "POST", "/asset/v1/content/assets/query", requestBody)
 }
 
 func generateCloudPageRequestBody(page int, pageSize int) ([]byte, error) {
@@ -1415,15 +819,12 @@ func generateCloudPageRequestBody(page int, pageSize int) ([]byte, error) {
 }
 
 // Function to process CloudPages with the "combine all content" logic
-func processCloudPages(cloudPages []CloudPage, deName, deCustomerKey, cloudPageID string, rawItems []interface{}) []CloudPage {
+func processCloudPages(items []map[string]interface{}, deName, deCustomerKey, cloudPageID string) []CloudPage {
     var filteredCloudPages []CloudPage
 
-    for i, cloudPage := range cloudPages {
-        // Access the raw item corresponding to this CloudPage
-        itemMap, ok := rawItems[i].(map[string]interface{})
-        if !ok {
-            continue
-        }
+    for _, itemMap := range items {
+        name, _ := itemMap["name"].(string)
+        cloudPage := CloudPage{Name: name}
 
         // Combine all "content" fields in the itemMap
         combinedHTML := combineAllContent(itemMap)
@@ -1459,118 +860,65 @@ func extractEventDefinitionKey(defaultEmail string) string {
     return defaultEmail[start : start+end]
 }
 
-func GetTriggeredSends(emailID string) ([]TriggeredSendDefinition, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    // Define the filter for TriggeredSendStatus and Email.ID
-    filter := fmt.Sprintf(`
-        <Filter xsi:type="par:ComplexFilterPart" xmlns:par="http://exacttarget.com/wsdl/partnerAPI">
-            <LeftOperand xsi:type="par:SimpleFilterPart">
-                <Property>TriggeredSendStatus</Property>
-                <SimpleOperator>notEquals</SimpleOperator>
-                <Value>Deleted</Value>
-            </LeftOperand>
-            <LogicalOperator>AND</LogicalOperator>
-            <RightOperand xsi:type="par:SimpleFilterPart">
-                <Property>Email.ID</Property>
-                <SimpleOperator>equals</SimpleOperator>
-                <Value>%s</Value>
-            </RightOperand>
-        </Filter>`, emailID)
-
-    // Prepare the SOAP request body for TriggeredSendDefinition
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "TriggeredSendDefinition", `
-        <Properties>Name</Properties>
-    `, filter)
-
-    // Perform the SOAP request
-    resp, err := soapRequest(requestBody)
-    if err != nil {
+func GetTriggeredSends(ctx context.Context, sessionID string, emailID string) ([]TriggeredSendDefinition, error) {
+    // Retrieve the TriggeredSendDefinitions that aren't deleted and use the email
+    results, err := retrieveAll[TriggeredSendDefinition](ctx, sessionID, RetrieveRequest{
+        ObjectType: "TriggeredSendDefinition",
+        Properties: []string{"Name"},
+        Filter:     And(NotEquals("TriggeredSendStatus", "Deleted"), Equals("Email.ID", emailID)),
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    // Parse the response into the appropriate structure
-    var response struct {
-        Results []TriggeredSendDefinition `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
-        return nil, err
-    }
-
-    // Regular expression to filter out names with hash values
-    hashRegex := regexp.MustCompile(`-\s*[a-f0-9]{32}$`)
-
     // Filter out TriggeredSendDefinitions with a hash in their name
     var filteredResults []TriggeredSendDefinition
-    for _, result := range response.Results {
-        if !hashRegex.MatchString(result.Name) {
+    for _, result := range results {
+        if !generatedTriggeredSendName(result.Name) {
             filteredResults = append(filteredResults, result)
         }
     }
 
-    log.Printf("Filtered out results with hash: %d remaining", len(filteredResults))
-    return filteredResults, nil
+    slog.DebugContext(ctx, "Filtered out results with hash", "remaining", len(filteredResults))
+    return filteredResults, err
 }
 
-func GetInitiatedEmails(deObjectID, emailID string) ([]EmailSendDefinition, error) {
-    var emailSendDefinitions []EmailSendDefinition
-
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    // SOAP request for retrieving EmailSendDefinition objects
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "EmailSendDefinition", `
-        <Properties>Name</Properties>
-        <Properties>ObjectID</Properties>
-        <Properties>SendDefinitionList</Properties>
-        <Properties>Email.ID</Properties>
-    `, "")
-
-    resp, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
+// Raw EmailSendDefinition row as returned by the SOAP API
+type emailSendDefinitionResult struct {
+    Name                string `xml:"Name"`
+    ObjectID            string `xml:"ObjectID"`
+    SendDefinitionList  struct {
+        CustomObjectID string `xml:"CustomObjectID"`
+        List           struct {
+            ID string `xml:"ID"`
+        } `xml:"List"`
+    } `xml:"SendDefinitionList"`
+    Email struct {
+        ID string `xml:"ID"`
+    } `xml:"Email"`
+}
 
-    var response struct {
-        Results []struct {
-            Name                string `xml:"Name"`
-            ObjectID            string `xml:"ObjectID"`
-            SendDefinitionList  struct {
-                CustomObjectID string `xml:"CustomObjectID"`
-                List           struct {
-                    ID string `xml:"ID"`
-                } `xml:"List"`
-            } `xml:"SendDefinitionList"`
-            Email struct {
-                ID string `xml:"ID"`
-            } `xml:"Email"`
-        } `xml:"Body>RetrieveResponseMsg>Results"`
-    }
+func GetInitiatedEmails(ctx context.Context, sessionID string, deObjectID, emailID string) ([]EmailSendDefinition, error) {
+    var emailSendDefinitions []EmailSendDefinition
 
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
+    // SOAP request for retrieving every EmailSendDefinition, read across all batches
+    results, err := retrieveAll[emailSendDefinitionResult](ctx, sessionID, RetrieveRequest{
+        ObjectType: "EmailSendDefinition",
+        Properties: []string{"Name", "ObjectID", "SendDefinitionList", "Email.ID"},
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    // Regular expression to match a pattern like "_1234567890", i.e., at least 10 digits after an underscore
-    re := regexp.MustCompile(`_([0-9]{10,})$`)
-
     // Filter and collect valid EmailSendDefinitions
-    for _, result := range response.Results {
+    for _, result := range results {
         // Exclude the result if CustomObjectID and Email.ID are both empty
         if result.SendDefinitionList.CustomObjectID == "" && result.Email.ID == "" {
             continue // Skip this result if CustomObjectID and Email.ID are empty
         }
 
         // Check if the result.Name contains an underscore followed by at least 10 digits
-        if re.MatchString(result.Name) {
+        if generatedSendDefinitionName(result.Name) {
             continue // Skip if the name ends with at least 10 digits after an underscore
         }
 
@@ -1598,130 +946,108 @@ func GetInitiatedEmails(deObjectID, emailID string) ([]EmailSendDefinition, erro
         }
     }
 
-    return emailSendDefinitions, nil
+    return emailSendDefinitions, err
 }
 
-func GetQueries(filter string) ([]QueryDefinition, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
-    }
-
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "QueryDefinition", `
-        <Properties>Name</Properties>
-        <Properties>ObjectID</Properties>
-    `, filter)
-
-    resp, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    var response struct {
-        Results []QueryDefinition `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
+func GetQueries(ctx context.Context, sessionID string, filter Filter) ([]QueryDefinition, error) {
+    results, err := retrieveAll[QueryDefinition](ctx, sessionID, RetrieveRequest{
+        ObjectType: "QueryDefinition",
+        Properties: []string{"Name", "ObjectID"},
+        Filter:     filter,
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    return response.Results, nil
+    return results, err
 }
 
-func GetImports(filter string) ([]ImportDefinition, error) {
-    token, err := auth.GetAccessToken() // Get the OAuth token or session token
-    if err != nil {
-        return nil, err
-    }
-
-    // Create the SOAP request for ImportDefinition
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "ImportDefinition", `
-        <Properties>Name</Properties>
-        <Properties>ObjectID</Properties>
-    `, filter)
-
-    // Send the SOAP request
-    resp, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    // Parse the response
-    var response struct {
-        Results []ImportDefinition `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
+func GetImports(ctx context.Context, sessionID string, filter Filter) ([]ImportDefinition, error) {
+    // Send the SOAP request for ImportDefinition
+    results, err := retrieveAll[ImportDefinition](ctx, sessionID, RetrieveRequest{
+        ObjectType: "ImportDefinition",
+        Properties: []string{"Name", "ObjectID"},
+        Filter:     filter,
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    // Regular expression to match UUID-like patterns
-    uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
-
     // Filter out results where Name matches the UUID pattern
     var validResults []ImportDefinition
-    for _, result := range response.Results {
-        if !uuidPattern.MatchString(result.Name) {
+    for _, result := range results {
+        if !generatedImportName(result.Name) {
             validResults = append(validResults, result)
         }
     }
 
     // Return the filtered list of ImportDefinition objects
-    return validResults, nil
+    return validResults, err
 }
 
-func GetFilters(filter string) ([]FilterActivity, error) {
-    token, err := auth.GetAccessToken() // Get the OAuth token or session token
-    if err != nil {
-        return nil, err
-    }
-
-    // Create the SOAP request for FilterActivity
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "FilterActivity", `
-        <Properties>Name</Properties>
-        <Properties>ObjectID</Properties>
-    `, filter)
-
-    // Send the SOAP request
-    resp, err := soapRequest(requestBody)
-    if err != nil {
+func GetFilters(ctx context.Context, sessionID string, filter Filter) ([]FilterActivity, error) {
+    // Send the SOAP request for FilterActivity
+    results, err := retrieveAll[FilterActivity](ctx, sessionID, RetrieveRequest{
+        ObjectType: "FilterActivity",
+        Properties: []string{"Name", "ObjectID"},
+        Filter:     filter,
+    })
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
-    // Parse the response
-    var response struct {
-        Results []FilterActivity `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
-        return nil, err
-    }
-
-    // Regular expression to match UUID-like patterns
-    uuidPattern := regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
-
     // Filter out results where Name contains UUID-like patterns or starts with "Activity for result group"
     var validResults []FilterActivity
-    for _, result := range response.Results {
-        if !uuidPattern.MatchString(result.Name) && !strings.HasPrefix(result.Name, "Activity for result group") {
+    for _, result := range results {
+        if !generatedFilterName(result.Name) {
             validResults = append(validResults, result)
         }
     }
 
     // Return the filtered list of FilterActivity objects
-    return validResults, nil
+    return validResults, err
+}
+
+// --- Generated Assets ---
+
+// Names SFMC gives the assets it creates for its own use, e.g. the send definition of a single send
+var (
+    hashSuffixPattern   = regexp.MustCompile(`-\s*[a-f0-9]{32}$`)
+    longNumberSuffix    = regexp.MustCompile(`_([0-9]{10,})$`)
+    uuidNamePattern     = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+    uuidInNamePattern   = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// generatedTriggeredSendName reports a triggered send created by a journey, its name ends with a hash
+func generatedTriggeredSendName(name string) bool {
+    return hashSuffixPattern.MatchString(name)
+}
+
+// generatedSendDefinitionName reports a send definition created for a single send, its name ends with a timestamp
+func generatedSendDefinitionName(name string) bool {
+    return longNumberSuffix.MatchString(name)
+}
+
+// generatedImportName reports an import created by a wizard, its name is a UUID
+func generatedImportName(name string) bool {
+    return uuidNamePattern.MatchString(name)
+}
+
+// generatedFilterName reports a filter created for a journey or result group
+func generatedFilterName(name string) bool {
+    return uuidInNamePattern.MatchString(name) || strings.HasPrefix(name, "Activity for result group")
 }
 
 // GetDataExtensionPath retrieves the folder path for a Data Extension by recursively finding parent folders
-func GetDataExtensionPath(categoryID string, shared bool) (string, error) {
+func GetDataExtensionPath(ctx context.Context, sessionID string, categoryID string, shared bool) (string, error) {
     var pathElements []string
     currentID := categoryID
 
     for {
         // Retrieve the folder information for the current folder ID
-        folder, err := getFolderByID(currentID, shared) // Pass shared flag
+        folder, err := getFolderByID(ctx, sessionID, currentID, shared) // Pass shared flag
         if err != nil {
-            return "", fmt.Errorf("failed to retrieve folder with ID %s: %v", currentID, err)
+            return "", fmt.Errorf("failed to retrieve folder with ID %s: %w", currentID, err)
         }
 
         // Check if the current folder is either "Shared Data Extensions" or "Data Extensions"
@@ -1747,120 +1073,56 @@ func GetDataExtensionPath(categoryID string, shared bool) (string, error) {
 }
 
 // Helper function to retrieve folder information by ID using a SOAP request
-func getFolderByID(folderID string, shared bool) (*Folder, error) {
-    token, err := auth.GetAccessToken()
-    if err != nil {
-        return nil, err
+func getFolderByID(ctx context.Context, sessionID string, folderID string, shared bool) (*Folder, error) {
+    request := RetrieveRequest{
+        ObjectType: "DataFolder",
+        Properties: []string{"ID", "Name", "ParentFolder.ID", "ParentFolder.Name"},
+        Filter:     Equals("ID", folderID),
     }
-
-    var filter string
     if shared {
-        // Use shared data extension filter logic
-        filter = fmt.Sprintf(`
-            <QueryAllAccounts>true</QueryAllAccounts>
-            <Filter xsi:type="ComplexFilterPart">
-                <LeftOperand xsi:type="SimpleFilterPart">
-                    <Property>ContentType</Property>
-                    <SimpleOperator>equals</SimpleOperator>
-                    <Value>shared_dataextension</Value>
-                </LeftOperand>
-                <LogicalOperator>AND</LogicalOperator>
-                <RightOperand xsi:type="SimpleFilterPart">
-                    <Property>ID</Property>
-                    <SimpleOperator>equals</SimpleOperator>
-                    <Value>%s</Value>
-                </RightOperand>
-            </Filter>`, folderID)
-    } else {
-        // Use non-shared data extension filter logic
-        filter = fmt.Sprintf(`
-            <Filter xsi:type="SimpleFilterPart">
-                <Property>ID</Property>
-                <SimpleOperator>equals</SimpleOperator>
-                <Value>%s</Value>
-            </Filter>`, folderID)
+        // Shared folders live in the parent business unit
+        request.Filter = And(Equals("ContentType", "shared_dataextension"), Equals("ID", folderID))
+        request.QueryAllAccounts = true
     }
 
-    requestBody := fmt.Sprintf(xmlTemplate, os.Getenv("SOAP_ENDPOINT"), token, "DataFolder", `
-        <Properties>ID</Properties>
-        <Properties>Name</Properties>
-        <Properties>ParentFolder.ID</Properties>
-        <Properties>ParentFolder.Name</Properties>
-    `, filter)
-
-    resp, err := soapRequest(requestBody)
-    if err != nil {
-        return nil, err
-    }
-
-    // Parse the SOAP response to extract the folder information
-    var response struct {
-        Results []Folder `xml:"Body>RetrieveResponseMsg>Results"`
-    }
-
-    err = xml.Unmarshal(resp, &response)
-    if err != nil {
+    results, err := retrieveAll[Folder](ctx, sessionID, request)
+    if err != nil && !errors.Is(err, ErrTruncated) {
         return nil, err
     }
 
     // Ensure that at least one result was returned
-    if len(response.Results) == 0 {
+    if len(results) == 0 {
         return nil, fmt.Errorf("folder with ID %s not found", folderID)
     }
 
-    return &response.Results[0], nil
+    return &results[0], nil
 }
 
 // --- Utility Functions ---
 
-// XML Template to use for all SOAP requests
-var xmlTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:u="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">
-    <s:Header>
-        <a:Action s:mustUnderstand="1">Retrieve</a:Action>
-        <a:To s:mustUnderstand="1">%s</a:To>
-        <fueloauth xmlns="http://exacttarget.com">%s</fueloauth>
-    </s:Header>
-    <s:Body xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
-        <RetrieveRequestMsg xmlns="http://exacttarget.com/wsdl/partnerAPI">
-            <RetrieveRequest>
-                <ObjectType>%s</ObjectType>
-                %s
-                %s <!-- Filter placeholder -->
-            </RetrieveRequest>
-        </RetrieveRequestMsg>
-    </s:Body>
-</s:Envelope>`
-
-// soapRequest function to do SOAP calls
-func soapRequest(body string) ([]byte, error) {
-
-    // Create and send the SOAP request
-    req, err := http.NewRequest("POST", os.Getenv("SOAP_ENDPOINT"), strings.NewReader(body))
-    if err != nil {
-        return nil, err
-    }
-
-    req.Header.Set("Content-Type", "text/xml")
-    client := &http.Client{Timeout: 30 * time.Second}
+// soapRequest function to do SOAP calls, the envelope is built for each attempt since it carries the token
+func soapRequest(ctx context.Context, sessionID string, buildEnvelope func(token string) (string, error)) ([]byte, error) {
+    statusCode, responseBody, err := send(ctx, sessionID, func(ctx context.Context, token string) (*http.Request, error) {
+        body, err := buildEnvelope(token)
+        if err != nil {
+            return nil, err
+        }
 
-    resp, err := client.Do(req)
+        req, err := http.NewRequestWithContext(ctx, "POST", os.Getenv("SOAP_ENDPOINT"), strings.NewReader(body))
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Content-Type", "text/xml")
+        return req, nil
+    })
     if err != nil {
         return nil, err
     }
-    defer resp.Body.Close()
-
-    // Check for successful status code
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("error: received non-200 response code: %d", resp.StatusCode)
-    }
 
-    // Read and return the response body
-    responseBody, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
+    // Turn SOAP Faults and non-200 responses into typed errors
+    if err := checkSOAPResponse(statusCode, responseBody); err != nil {
         return nil, err
     }
 
     return responseBody, nil
 }
-
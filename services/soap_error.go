@@ -0,0 +1,156 @@
+package services
+
+import (
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// SOAPErrorKind tells callers what went wrong with a SOAP call and whether retrying or logging in again can help
+type SOAPErrorKind string
+
+const (
+    SOAPAuthExpired      SOAPErrorKind = "auth_expired"      // The access token was rejected
+    SOAPPermissionDenied SOAPErrorKind = "permission_denied" // The installed package is missing a scope or the user a role
+    SOAPInvalidProperty  SOAPErrorKind = "invalid_property"  // A requested or filtered property doesn't exist on the object
+    SOAPThrottled        SOAPErrorKind = "throttled"         // SFMC is rate limiting the account
+    SOAPServerError      SOAPErrorKind = "server_error"      // Anything else SFMC reported as a failure
+)
+
+// Sentinel errors matching every SOAPError of the corresponding kind, for use with errors.Is
+var (
+    ErrAuthExpired      = errors.New("SFMC rejected the access token")
+    ErrPermissionDenied = errors.New("permission denied by SFMC")
+    ErrInvalidProperty  = errors.New("invalid SOAP property")
+    ErrThrottled        = errors.New("SFMC rate limit reached")
+    ErrServerError      = errors.New("SFMC server error")
+)
+
+var soapErrorSentinels = map[SOAPErrorKind]error{
+    SOAPAuthExpired:      ErrAuthExpired,
+    SOAPPermissionDenied: ErrPermissionDenied,
+    SOAPInvalidProperty:  ErrInvalidProperty,
+    SOAPThrottled:        ErrThrottled,
+    SOAPServerError:      ErrServerError,
+}
+
+// SOAPError is a SOAP Fault or an OverallStatus of "Error: ..." returned by SFMC
+type SOAPError struct {
+    Kind       SOAPErrorKind
+    ObjectType string // Object the Retrieve was for, if known
+    StatusCode int    // HTTP status of the response
+    FaultCode  string // Empty when the error came from OverallStatus
+    Message    string
+}
+
+func (e *SOAPError) Error() string {
+    if e.ObjectType != "" {
+        return fmt.Sprintf("SOAP %s retrieve failed (%s): %s", e.ObjectType, e.Kind, e.Message)
+    }
+    return fmt.Sprintf("SOAP call failed (%s): %s", e.Kind, e.Message)
+}
+
+func (e *SOAPError) Is(target error) bool {
+    return soapErrorSentinels[e.Kind] == target
+}
+
+// Both the SOAP 1.1 and 1.2 fault layouts, SFMC answers with either depending on the endpoint
+type soapFaultEnvelope struct {
+    Fault *struct {
+        FaultCode   string `xml:"faultcode"`
+        FaultString string `xml:"faultstring"`
+        Code        string `xml:"Code>Value"`
+        Subcode     string `xml:"Code>Subcode>Value"`
+        Reason      string `xml:"Reason>Text"`
+    } `xml:"Body>Fault"`
+}
+
+// checkSOAPResponse returns a SOAPError when the response is a fault or a non-200 status
+func checkSOAPResponse(statusCode int, body []byte) error {
+    var envelope soapFaultEnvelope
+    if err := xml.Unmarshal(body, &envelope); err == nil && envelope.Fault != nil {
+        fault := envelope.Fault
+        code := firstNonEmpty(fault.FaultCode, fault.Subcode, fault.Code)
+        message := strings.TrimSpace(firstNonEmpty(fault.FaultString, fault.Reason))
+
+        return &SOAPError{
+            Kind:       classifySOAPError(statusCode, code, message),
+            StatusCode: statusCode,
+            FaultCode:  code,
+            Message:    message,
+        }
+    }
+
+    if statusCode != http.StatusOK {
+        return &SOAPError{
+            Kind:       classifySOAPError(statusCode, "", ""),
+            StatusCode: statusCode,
+            Message:    fmt.Sprintf("received non-200 response code: %d", statusCode),
+        }
+    }
+    return nil
+}
+
+// overallStatusError turns a Retrieve's "Error: ..." OverallStatus into a SOAPError, other statuses are not errors
+func overallStatusError(objectType, overallStatus string) error {
+    if !strings.HasPrefix(overallStatus, "Error") {
+        return nil
+    }
+
+    message := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(overallStatus, "Error"), ":"))
+    return &SOAPError{
+        Kind:       classifySOAPError(http.StatusOK, "", message),
+        ObjectType: objectType,
+        StatusCode: http.StatusOK,
+        Message:    message,
+    }
+}
+
+// classifySOAPError works out the kind from the HTTP status first and then from the fault code and message
+// SFMC doesn't use stable codes for these, so matching the wording is the only option
+func classifySOAPError(statusCode int, code, message string) SOAPErrorKind {
+    switch statusCode {
+    case http.StatusUnauthorized:
+        return SOAPAuthExpired
+    case http.StatusForbidden:
+        return SOAPPermissionDenied
+    case http.StatusTooManyRequests:
+        return SOAPThrottled
+    }
+
+    text := strings.ToLower(code + " " + message)
+    switch {
+    case containsAny(text, "login failed", "token expired", "expired token", "invalid token", "security"):
+        return SOAPAuthExpired
+    case containsAny(text, "throttl", "rate limit", "too many requests", "concurrent request"):
+        return SOAPThrottled
+    case containsAny(text, "insufficient privileges", "permission", "not authorized", "access denied", "unauthorized"):
+        return SOAPPermissionDenied
+    case containsAny(text, "propert", "unknown field", "do not match with the fields"):
+        return SOAPInvalidProperty
+    default:
+        return SOAPServerError
+    }
+}
+
+// Helper function to check a text for any of the substrings
+func containsAny(text string, substrings ...string) bool {
+    for _, substring := range substrings {
+        if strings.Contains(text, substring) {
+            return true
+        }
+    }
+    return false
+}
+
+// Helper function to pick the first value that's set
+func firstNonEmpty(values ...string) string {
+    for _, value := range values {
+        if value != "" {
+            return value
+        }
+    }
+    return ""
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+    "context"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+)
+
+// --- SOAP Filter Builder ---
+
+// Filter is a SOAP filter tree node, either a SimpleFilterPart or a ComplexFilterPart
+type Filter interface {
+    xml.Marshaler
+    isFilter()
+}
+
+// Simple operators supported by the SFMC SOAP API
+const (
+    OperatorEquals             = "equals"
+    OperatorNotEquals          = "notEquals"
+    OperatorGreaterThan        = "greaterThan"
+    OperatorGreaterThanOrEqual = "greaterThanOrEqual"
+    OperatorLessThan           = "lessThan"
+    OperatorLessThanOrEqual    = "lessThanOrEqual"
+    OperatorLike               = "like"
+    OperatorIn                 = "IN"
+    OperatorBetween            = "between"
+    OperatorIsNull             = "isNull"
+    OperatorIsNotNull          = "isNotNull"
+)
+
+// Logical operators joining the two sides of a ComplexFilterPart
+const (
+    LogicalAnd = "AND"
+    LogicalOr  = "OR"
+)
+
+// SimpleFilterPart compares one property against one or more values
+type SimpleFilterPart struct {
+    Property       string
+    SimpleOperator string
+    Values         []string // Two values for between, any number for IN
+}
+
+// ComplexFilterPart joins two filters with AND or OR
+type ComplexFilterPart struct {
+    LeftOperand     Filter
+    LogicalOperator string
+    RightOperand    Filter
+}
+
+func (SimpleFilterPart) isFilter()  {}
+func (ComplexFilterPart) isFilter() {}
+
+// Equals matches the property exactly
+func Equals(property, value string) SimpleFilterPart {
+    return SimpleFilterPart{Property: property, SimpleOperator: OperatorEquals, Values: []string{value}}
+}
+
+// NotEquals matches every other value of the property
+func NotEquals(property, value string) SimpleFilterPart {
+    return SimpleFilterPart{Property: property, SimpleOperator: OperatorNotEquals, Values: []string{value}}
+}
+
+// Like matches the property against a pattern
+func Like(property, pattern string) SimpleFilterPart {
+    return SimpleFilterPart{Property: property, SimpleOperator: OperatorLike, Values: []string{pattern}}
+}
+
+// In matches any of the values
+func In(property string, values ...string) SimpleFilterPart {
+    return SimpleFilterPart{Property: property, SimpleOperator: OperatorIn, Values: values}
+}
+
+// Between matches values from low to high inclusive
+func Between(property, low, high string) SimpleFilterPart {
+    return SimpleFilterPart{Property: property, SimpleOperator: OperatorBetween, Values: []string{low, high}}
+}
+
+// And joins every filter with AND, nesting them from the left
+func And(left, right Filter, more ...Filter) ComplexFilterPart {
+    return join(LogicalAnd, left, right, more)
+}
+
+// Or joins every filter with OR, nesting them from the left
+func Or(left, right Filter, more ...Filter) ComplexFilterPart {
+    return join(LogicalOr, left, right, more)
+}
+
+// Helper function to fold a list of filters into nested ComplexFilterParts
+func join(operator string, left, right Filter, more []Filter) ComplexFilterPart {
+    part := ComplexFilterPart{LeftOperand: left, LogicalOperator: operator, RightOperand: right}
+    for _, next := range more {
+        part = ComplexFilterPart{LeftOperand: part, LogicalOperator: operator, RightOperand: next}
+    }
+    return part
+}
+
+// xsiType adds the xsi:type attribute SFMC uses to tell filter parts apart
+func xsiType(start xml.StartElement, typeName string) xml.StartElement {
+    start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xsi:type"}, Value: typeName})
+    return start
+}
+
+// MarshalXML writes the part under whatever element it's used as (Filter, LeftOperand or RightOperand)
+func (f SimpleFilterPart) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    body := struct {
+        Property       string   `xml:"Property"`
+        SimpleOperator string   `xml:"SimpleOperator"`
+        Values         []string `xml:"Value"`
+    }{f.Property, f.SimpleOperator, f.Values}
+
+    return e.EncodeElement(body, xsiType(start, "SimpleFilterPart"))
+}
+
+// MarshalXML writes the part and both of its operands
+func (f ComplexFilterPart) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    if f.LeftOperand == nil || f.RightOperand == nil {
+        return fmt.Errorf("complex filter part needs both operands")
+    }
+
+    body := struct {
+        LeftOperand     Filter `xml:"LeftOperand"`
+        LogicalOperator string `xml:"LogicalOperator"`
+        RightOperand    Filter `xml:"RightOperand"`
+    }{f.LeftOperand, f.LogicalOperator, f.RightOperand}
+
+    return e.EncodeElement(body, xsiType(start, "ComplexFilterPart"))
+}
+
+// --- Retrieve Envelope ---
+
+// RetrieveRequest describes a SOAP Retrieve call
+type RetrieveRequest struct {
+    ObjectType       string
+    Properties       []string
+    Filter           Filter // Optional
+    QueryAllAccounts bool   // Search every business unit, needed for shared items
+    ContinueRequest  string // RequestID of the previous batch when reading the next one
+}
+
+// Element order follows the partner API WSDL
+type retrieveRequestXML struct {
+    ObjectType       string   `xml:"ObjectType,omitempty"`
+    Properties       []string `xml:"Properties"`
+    Filter           Filter   `xml:"Filter,omitempty"`
+    ContinueRequest  string   `xml:"ContinueRequest,omitempty"`
+    QueryAllAccounts bool     `xml:"QueryAllAccounts,omitempty"`
+}
+
+type soapEnvelope struct {
+    XMLName xml.Name `xml:"s:Envelope"`
+    XmlnsS  string   `xml:"xmlns:s,attr"`
+    XmlnsA  string   `xml:"xmlns:a,attr"`
+    XmlnsU  string   `xml:"xmlns:u,attr"`
+    Header  struct {
+        Action    soapHeaderValue `xml:"a:Action"`
+        To        soapHeaderValue `xml:"a:To"`
+        FuelOAuth struct {
+            Xmlns string `xml:"xmlns,attr"`
+            Token string `xml:",chardata"`
+        } `xml:"fueloauth"`
+    } `xml:"s:Header"`
+    Body struct {
+        XmlnsXsi string `xml:"xmlns:xsi,attr"`
+        XmlnsXsd string `xml:"xmlns:xsd,attr"`
+        Message  struct {
+            Xmlns   string             `xml:"xmlns,attr"`
+            Request retrieveRequestXML `xml:"RetrieveRequest"`
+        } `xml:"RetrieveRequestMsg"`
+    } `xml:"s:Body"`
+}
+
+type soapHeaderValue struct {
+    MustUnderstand string `xml:"s:mustUnderstand,attr"`
+    Value          string `xml:",chardata"`
+}
+
+// buildRetrieveEnvelope serializes the Retrieve call, escaping every value
+func buildRetrieveEnvelope(token string, request RetrieveRequest) (string, error) {
+    var envelope soapEnvelope
+    envelope.XmlnsS = "http://www.w3.org/2003/05/soap-envelope"
+    envelope.XmlnsA = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+    envelope.XmlnsU = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+    envelope.Header.Action = soapHeaderValue{MustUnderstand: "1", Value: "Retrieve"}
+    envelope.Header.To = soapHeaderValue{MustUnderstand: "1", Value: os.Getenv("SOAP_ENDPOINT")}
+    envelope.Header.FuelOAuth.Xmlns = "http://exacttarget.com"
+    envelope.Header.FuelOAuth.Token = token
+    envelope.Body.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+    envelope.Body.XmlnsXsd = "http://www.w3.org/2001/XMLSchema"
+    envelope.Body.Message.Xmlns = "http://exacttarget.com/wsdl/partnerAPI"
+    envelope.Body.Message.Request = retrieveRequestXML{
+        ObjectType:       request.ObjectType,
+        Properties:       request.Properties,
+        Filter:           request.Filter,
+        ContinueRequest:  request.ContinueRequest,
+        QueryAllAccounts: request.QueryAllAccounts,
+    }
+
+    body, err := xml.MarshalIndent(envelope, "", "    ")
+    if err != nil {
+        return "", fmt.Errorf("failed to build SOAP envelope: %v", err)
+    }
+    return xml.Header + string(body), nil
+}
+
+// retrieve runs a SOAP Retrieve call with the session's token and returns the raw response
+func retrieve(ctx context.Context, sessionID string, request RetrieveRequest) ([]byte, error) {
+    body, err := soapRequest(ctx, sessionID, func(token string) (string, error) {
+        return buildRetrieveEnvelope(token, request)
+    })
+    var soapErr *SOAPError
+    if errors.As(err, &soapErr) {
+        soapErr.ObjectType = request.ObjectType
+    }
+    return body, err
+}
+
+// --- Retrieve Paging ---
+
+// Default number of Retrieve batches (up to 2,500 rows each) read before a result set is cut off
+const defaultSOAPMaxPages = 20
+
+// ErrTruncated matches every TruncatedError, the results returned with it are incomplete but usable
+var ErrTruncated = errors.New("result set truncated")
+
+// TruncatedError is returned along with the rows read so far when a Retrieve hits the page cap
+type TruncatedError struct {
+    ObjectType string
+    Pages      int
+    Rows       int
+}
+
+func (e *TruncatedError) Error() string {
+    return fmt.Sprintf("%s results truncated after %d pages (%d rows), raise SOAP_MAX_PAGES to read more", e.ObjectType, e.Pages, e.Rows)
+}
+
+func (e *TruncatedError) Is(target error) bool {
+    return target == ErrTruncated
+}
+
+// soapMaxPages reads the page cap from SOAP_MAX_PAGES
+func soapMaxPages() int {
+    if maxPages, err := strconv.Atoi(os.Getenv("SOAP_MAX_PAGES")); err == nil && maxPages > 0 {
+        return maxPages
+    }
+    return defaultSOAPMaxPages
+}
+
+// retrieveResponse is one Retrieve batch
+type retrieveResponse[T any] struct {
+    OverallStatus string `xml:"Body>RetrieveResponseMsg>OverallStatus"`
+    RequestID     string `xml:"Body>RetrieveResponseMsg>RequestID"`
+    Results       []T    `xml:"Body>RetrieveResponseMsg>Results"`
+}
+
+// retrieveAll runs a Retrieve and follows MoreDataAvailable with ContinueRequest until every batch is read or the cap is hit
+func retrieveAll[T any](ctx context.Context, sessionID string, request RetrieveRequest) ([]T, error) {
+    var results []T
+    maxPages := soapMaxPages()
+
+    for page := 1; ; page++ {
+        body, err := retrieve(ctx, sessionID, request)
+        if err != nil {
+            return nil, err
+        }
+
+        var response retrieveResponse[T]
+        if err := xml.Unmarshal(body, &response); err != nil {
+            return nil, err
+        }
+        // A bad property or missing permission comes back as a 200 with an error status and no results
+        if err := overallStatusError(request.ObjectType, response.OverallStatus); err != nil {
+            return nil, err
+        }
+        count(ctx, pagesCounted)
+        results = append(results, response.Results...)
+
+        if response.OverallStatus != "MoreDataAvailable" {
+            return results, nil
+        }
+        if page >= maxPages {
+            return results, &TruncatedError{ObjectType: request.ObjectType, Pages: page, Rows: len(results)}
+        }
+
+        // The next batch only needs the RequestID, the original filter and properties are kept by SFMC
+        request = RetrieveRequest{ObjectType: request.ObjectType, ContinueRequest: response.RequestID}
+    }
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+    "encoding/xml"
+    "strings"
+    "testing"
+)
+
+// Helper function to write a filter the way the Retrieve envelope does
+func marshalFilter(t *testing.T, filter Filter) string {
+    body, err := xml.Marshal(struct {
+        XMLName xml.Name `xml:"RetrieveRequest"`
+        Filter  Filter   `xml:"Filter"`
+    }{Filter: filter})
+    if err != nil {
+        t.Fatalf("xml.Marshal(%v) failed: %v", filter, err)
+    }
+    return strings.TrimSuffix(strings.TrimPrefix(string(body), "<RetrieveRequest>"), "</RetrieveRequest>")
+}
+
+func TestSimpleFilterPartEscapesItsValues(t *testing.T) {
+    tests := []struct {
+        name   string
+        filter SimpleFilterPart
+        want   string
+    }{
+        {
+            "equals",
+            Equals("Name", "Orders"),
+            `<Filter xsi:type="SimpleFilterPart"><Property>Name</Property><SimpleOperator>equals</SimpleOperator><Value>Orders</Value></Filter>`,
+        },
+        {
+            "markup in the value",
+            Equals("Name", `a<b & "c"`),
+            `<Filter xsi:type="SimpleFilterPart"><Property>Name</Property><SimpleOperator>equals</SimpleOperator><Value>a&lt;b &amp; &#34;c&#34;</Value></Filter>`,
+        },
+        {
+            "value closing its element",
+            Like("Name", "%</Value><Value>x%"),
+            `<Filter xsi:type="SimpleFilterPart"><Property>Name</Property><SimpleOperator>like</SimpleOperator><Value>%&lt;/Value&gt;&lt;Value&gt;x%</Value></Filter>`,
+        },
+        {
+            "IN",
+            In("CustomerKey", "a", "b&c"),
+            `<Filter xsi:type="SimpleFilterPart"><Property>CustomerKey</Property><SimpleOperator>IN</SimpleOperator><Value>a</Value><Value>b&amp;c</Value></Filter>`,
+        },
+        {
+            "between",
+            Between("ModifiedDate", "2024-01-01", "2024-12-31"),
+            `<Filter xsi:type="SimpleFilterPart"><Property>ModifiedDate</Property><SimpleOperator>between</SimpleOperator><Value>2024-01-01</Value><Value>2024-12-31</Value></Filter>`,
+        },
+    }
+    for _, test := range tests {
+        if got := marshalFilter(t, test.filter); got != test.want {
+            t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+        }
+    }
+}
+
+func TestComplexFilterPartNestsFromTheLeft(t *testing.T) {
+    a := `<Property>A</Property><SimpleOperator>equals</SimpleOperator><Value>1</Value>`
+    b := `<Property>B</Property><SimpleOperator>notEquals</SimpleOperator><Value>2</Value>`
+    c := `<Property>C</Property><SimpleOperator>like</SimpleOperator><Value>3%</Value>`
+
+    tests := []struct {
+        name   string
+        filter ComplexFilterPart
+        want   string
+    }{
+        {
+            "two operands",
+            Or(Equals("A", "1"), NotEquals("B", "2")),
+            `<Filter xsi:type="ComplexFilterPart">` +
+                `<LeftOperand xsi:type="SimpleFilterPart">` + a + `</LeftOperand>` +
+                `<LogicalOperator>OR</LogicalOperator>` +
+                `<RightOperand xsi:type="SimpleFilterPart">` + b + `</RightOperand>` +
+                `</Filter>`,
+        },
+        {
+            "three operands",
+            And(Equals("A", "1"), NotEquals("B", "2"), Like("C", "3%")),
+            `<Filter xsi:type="ComplexFilterPart">` +
+                `<LeftOperand xsi:type="ComplexFilterPart">` +
+                `<LeftOperand xsi:type="SimpleFilterPart">` + a + `</LeftOperand>` +
+                `<LogicalOperator>AND</LogicalOperator>` +
+                `<RightOperand xsi:type="SimpleFilterPart">` + b + `</RightOperand>` +
+                `</LeftOperand>` +
+                `<LogicalOperator>AND</LogicalOperator>` +
+                `<RightOperand xsi:type="SimpleFilterPart">` + c + `</RightOperand>` +
+                `</Filter>`,
+        },
+        {
+            "nested OR",
+            And(Equals("A", "1"), Or(NotEquals("B", "2"), Like("C", "3%"))),
+            `<Filter xsi:type="ComplexFilterPart">` +
+                `<LeftOperand xsi:type="SimpleFilterPart">` + a + `</LeftOperand>` +
+                `<LogicalOperator>AND</LogicalOperator>` +
+                `<RightOperand xsi:type="ComplexFilterPart">` +
+                `<LeftOperand xsi:type="SimpleFilterPart">` + b + `</LeftOperand>` +
+                `<LogicalOperator>OR</LogicalOperator>` +
+                `<RightOperand xsi:type="SimpleFilterPart">` + c + `</RightOperand>` +
+                `</RightOperand>` +
+                `</Filter>`,
+        },
+    }
+    for _, test := range tests {
+        if got := marshalFilter(t, test.filter); got != test.want {
+            t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+        }
+    }
+
+    if _, err := xml.Marshal(ComplexFilterPart{LeftOperand: Equals("A", "1"), LogicalOperator: LogicalAnd}); err == nil {
+        t.Errorf("marshaling a ComplexFilterPart without a right operand succeeded")
+    }
+}
+
+func TestRetrieveEnvelopeKeepsFilterValuesIntact(t *testing.T) {
+    values := []string{
+        `Orders`,
+        `a<b & "c"`,
+        `]]></Value></Filter><Filter><Value>x`,
+    }
+    for _, value := range values {
+        envelope, err := buildRetrieveEnvelope("token", RetrieveRequest{
+            ObjectType: "DataExtension",
+            Properties: []string{"Name"},
+            Filter:     And(Equals("Name", value), Equals("CustomerKey", "key")),
+        })
+        if err != nil {
+            t.Fatalf("buildRetrieveEnvelope(%q) failed: %v", value, err)
+        }
+
+        var parsed struct {
+            Values []string `xml:"Body>RetrieveRequestMsg>RetrieveRequest>Filter>LeftOperand>Value"`
+        }
+        if err := xml.Unmarshal([]byte(envelope), &parsed); err != nil {
+            t.Fatalf("envelope for %q isn't valid XML: %v", value, err)
+        }
+        if len(parsed.Values) != 1 || parsed.Values[0] != value {
+            t.Errorf("envelope for %q carries the values %q", value, parsed.Values)
+        }
+    }
+}
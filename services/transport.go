@@ -0,0 +1,296 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math/rand"
+    "net"
+    "net/http"
+    neturl "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "asset_relationship_finder/auth"
+)
+
+// --- Shared Transport ---
+
+const (
+    requestTimeout = 30 * time.Second // Per attempt, so a retry gets the full time again
+    maxRetries     = 4                // Retries after the first attempt for 429s, 5xx and network errors
+    baseBackoff    = 500 * time.Millisecond
+    maxBackoff     = 10 * time.Second
+    maxRetryAfter  = 60 * time.Second // Longer waits asked for by SFMC are capped
+)
+
+// One client for every SFMC call so connections are pooled and reused across requests
+var httpClient = &http.Client{
+    Transport: &http.Transport{
+        Proxy:                 http.ProxyFromEnvironment,
+        MaxIdleConns:          100,
+        MaxIdleConnsPerHost:   50, // Page fetches run concurrently against the same host
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   10 * time.Second,
+        ExpectContinueTimeout: 1 * time.Second,
+        ForceAttemptHTTP2:     true,
+    },
+}
+
+// requestBuilder creates the request for one attempt with NewRequestWithContext, it's called again on every retry
+// so the body can be resent and with the new token after a re-authentication
+type requestBuilder func(ctx context.Context, token string) (*http.Request, error)
+
+// send runs the request with the session's token, retrying 429s, transient 5xx and network errors with backoff,
+// and re-authenticating once if SFMC rejects the token. It returns the final status and body.
+// Canceling ctx aborts the call in flight and any wait before the next attempt.
+func send(ctx context.Context, sessionID string, build requestBuilder) (int, []byte, error) {
+    token, err := auth.GetAccessToken(ctx, sessionID)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    reauthenticated := false
+    for attempt := 0; ; attempt++ {
+        statusCode, header, body, err := sendOnce(ctx, sessionID, token, build)
+        if err != nil {
+            // Nothing to retry once the caller has given up, or when the request couldn't even be built
+            if ctx.Err() != nil || attempt >= maxRetries || !retryableError(err) {
+                return 0, nil, err
+            }
+            wait := backoff(attempt)
+            slog.WarnContext(ctx, "SFMC request failed, retrying", "attempt", attempt+1, "wait_ms", wait.Milliseconds(), "error", err)
+            if err := sleep(ctx, wait); err != nil {
+                return 0, nil, err
+            }
+            continue
+        }
+
+        // The token can be revoked or expire early, get a new one and try again, but only once
+        if !reauthenticated && tokenRejected(statusCode, body) {
+            reauthenticated = true
+            slog.InfoContext(ctx, "SFMC rejected the access token, re-authenticating", "status", statusCode)
+            token, err = auth.RefreshAccessToken(ctx, sessionID, token)
+            if err != nil {
+                return 0, nil, err
+            }
+            continue
+        }
+
+        if attempt < maxRetries && retryableResponse(statusCode, body) {
+            wait := backoff(attempt)
+            if retryAfter, ok := parseRetryAfter(header.Get("Retry-After")); ok {
+                wait = retryAfter
+            }
+            slog.WarnContext(ctx, "SFMC request throttled or failed, retrying", "status", statusCode, "attempt", attempt+1, "wait_ms", wait.Milliseconds())
+            if err := sleep(ctx, wait); err != nil {
+                return 0, nil, err
+            }
+            continue
+        }
+
+        return statusCode, body, nil
+    }
+}
+
+// sendOnce runs a single attempt within the tenant's API budget, with its own timeout, and reads the whole body
+func sendOnce(ctx context.Context, sessionID, token string, build requestBuilder) (int, http.Header, []byte, error) {
+    release, err := acquireBudget(ctx, sessionID)
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    defer release()
+    count(ctx, callsCounted)
+
+    ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+    defer cancel()
+
+    req, err := build(ctx, token)
+    if err != nil {
+        return 0, nil, nil, err
+    }
+
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    return resp.StatusCode, resp.Header, body, nil
+}
+
+// tokenRejected reports whether the response says the access token is no longer valid
+// REST answers with a 401, SOAP with a fault that usually comes with a 500
+func tokenRejected(statusCode int, body []byte) bool {
+    if statusCode == http.StatusOK {
+        return false
+    }
+    return statusCode == http.StatusUnauthorized || errors.Is(checkSOAPResponse(statusCode, body), ErrAuthExpired)
+}
+
+// retryableError reports whether an attempt failed on the network and may succeed when tried again, errors building
+// the request never will
+func retryableError(err error) bool {
+    if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+        return true
+    }
+
+    // Every error from the client comes as a *url.Error, which is a net.Error itself, so look at what it wraps
+    var urlErr *neturl.Error
+    if errors.As(err, &urlErr) {
+        err = urlErr.Err
+    }
+    var netErr net.Error
+    return errors.As(err, &netErr)
+}
+
+// retryableResponse reports whether trying again can help, a SOAP fault for a bad property or a missing permission can't
+func retryableResponse(statusCode int, body []byte) bool {
+    switch statusCode {
+    case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    case http.StatusInternalServerError:
+        err := checkSOAPResponse(statusCode, body)
+        return errors.Is(err, ErrServerError) || errors.Is(err, ErrThrottled)
+    }
+    return false
+}
+
+// backoff returns the wait before the next attempt, exponential with full jitter so concurrent callers spread out
+func backoff(attempt int) time.Duration {
+    ceiling := baseBackoff << attempt
+    if ceiling > maxBackoff || ceiling <= 0 {
+        ceiling = maxBackoff
+    }
+    return time.Duration(rand.Int63n(int64(ceiling))) + baseBackoff/2
+}
+
+// sleep waits for the duration unless ctx is done first
+func sleep(ctx context.Context, wait time.Duration) error {
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return 0, false
+    }
+
+    var wait time.Duration
+    if seconds, err := strconv.Atoi(value); err == nil {
+        wait = time.Duration(seconds) * time.Second
+    } else if date, err := http.ParseTime(value); err == nil {
+        wait = time.Until(date)
+    } else {
+        return 0, false
+    }
+
+    if wait < 0 {
+        wait = 0
+    }
+    if wait > maxRetryAfter {
+        wait = maxRetryAfter
+    }
+    return wait, true
+}
+
+// --- Call and Page Counting ---
+
+// Counter counts what's done with a context, e.g. the SFMC calls made or the pages read
+type Counter struct {
+    n atomic.Int64
+}
+
+// Count returns the number counted so far
+func (c *Counter) Count() int {
+    return int(c.n.Load())
+}
+
+// counterKey is what a Counter counts, and the context key it's stored under
+type counterKey int
+
+const (
+    callsCounted counterKey = iota // Every attempt and retry of an SFMC call
+    pagesCounted                   // REST pages and SOAP batches read
+)
+
+// WithCallCounter returns a context whose SFMC calls are counted by the returned counter
+func WithCallCounter(ctx context.Context) (context.Context, *Counter) {
+    return withCounter(ctx, callsCounted)
+}
+
+// WithPageCounter returns a context whose pages and SOAP batches are counted by the returned counter, so long scans can
+// report their progress
+func WithPageCounter(ctx context.Context) (context.Context, *Counter) {
+    return withCounter(ctx, pagesCounted)
+}
+
+func withCounter(ctx context.Context, key counterKey) (context.Context, *Counter) {
+    counter := &Counter{}
+    return context.WithValue(ctx, key, counter), counter
+}
+
+// count adds one to the context's counter of the kind, if it has one
+func count(ctx context.Context, key counterKey) {
+    if counter, ok := ctx.Value(key).(*Counter); ok {
+        counter.n.Add(1)
+    }
+}
+
+// --- REST Calls ---
+
+// restRequest sends a REST call to the REST_ENDPOINT path and returns the body of a 200 response
+func restRequest(ctx context.Context, sessionID, method, path string, body []byte) ([]byte, error) {
+    url := os.Getenv("REST_ENDPOINT") + path
+    statusCode, responseBody, err := send(ctx, sessionID, func(ctx context.Context, token string) (*http.Request, error) {
+        var reader io.Reader
+        if body != nil {
+            reader = bytes.NewReader(body)
+        }
+
+        req, err := http.NewRequestWithContext(ctx, method, url, reader)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+        if body != nil {
+            req.Header.Set("Content-Type", "application/json")
+        }
+        return req, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Still rejected after the re-authentication, the user has to log in again
+    if statusCode == http.StatusUnauthorized {
+        return nil, fmt.Errorf("%w: REST call answered 401", ErrAuthExpired)
+    }
+    if statusCode != http.StatusOK {
+        return nil, fmt.Errorf("non-200 response code: %d, body: %s", statusCode, string(responseBody))
+    }
+    return responseBody, nil
+}